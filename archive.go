@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Форматы загружаемых файлов
+const (
+	formatCSV  = ".csv"
+	formatGzip = ".csv.gz"
+	formatZip  = ".zip"
+)
+
+// unsupportedFormatMessage ответ на файл неподдерживаемого формата
+const unsupportedFormatMessage = "Можно загружать только CSV файлы (.csv, .csv.gz или .zip с одним CSV)"
+
+// uploadFormat возвращает формат файла по имени или пустую строку, если формат не поддерживается
+func uploadFormat(name string) string {
+	lower := strings.ToLower(name)
+	for _, format := range []string{formatGzip, formatCSV, formatZip} {
+		if strings.HasSuffix(lower, format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// csvFileName возвращает имя CSV файла для сжатого: report.csv.gz и report.zip - report.csv
+func csvFileName(name string) string {
+	switch uploadFormat(name) {
+	case formatGzip:
+		return name[:len(name)-len(".gz")]
+	case formatZip:
+		return name[:len(name)-len(formatZip)] + formatCSV
+	}
+	return name
+}
+
+// maxCSVBytes максимальный размер CSV файла после распаковки из MAX_CSV_SIZE_MB
+func maxCSVBytes() int64 {
+	return int64(cfg().MaxCSVSizeMB) << 20
+}
+
+// prepareCSV распаковывает .csv.gz или .zip во временный CSV файл; для .csv возвращает
+// исходный путь. cleanup удаляет временный файл
+func prepareCSV(filePath, fileName string) (csvPath string, cleanup func(), err error) {
+	format := uploadFormat(fileName)
+	if format == formatCSV {
+		return filePath, func() {}, nil
+	}
+
+	tempFile, err := os.CreateTemp("", "unpacked-*.csv")
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка создания временного файла: %v", err)
+	}
+	cleanup = func() { os.Remove(tempFile.Name()) }
+
+	switch format {
+	case formatGzip:
+		err = extractGzip(filePath, tempFile)
+	case formatZip:
+		err = extractZip(filePath, tempFile)
+	default:
+		err = fmt.Errorf("неподдерживаемый формат файла %s", fileName)
+	}
+	tempFile.Close()
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tempFile.Name(), cleanup, nil
+}
+
+// extractGzip распаковывает .csv.gz в dst
+func extractGzip(filePath string, dst io.Writer) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("файл не является gzip архивом: %v", err)
+	}
+	defer reader.Close()
+
+	return copyUnpacked(dst, reader)
+}
+
+// extractZip распаковывает единственный CSV файл из .zip в dst
+func extractZip(filePath string, dst io.Writer) error {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return fmt.Errorf("файл не является zip архивом: %v", err)
+	}
+	defer archive.Close()
+
+	var csvEntry *zip.File
+	for _, entry := range archive.File {
+		// Служебные файлы архиваторов macOS и каталоги пропускаем
+		if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") {
+			continue
+		}
+		if !strings.EqualFold(path.Ext(entry.Name), formatCSV) {
+			return fmt.Errorf("в архиве должен быть только CSV файл, найден %s", entry.Name)
+		}
+		if csvEntry != nil {
+			return fmt.Errorf("в архиве больше одного CSV файла")
+		}
+		csvEntry = entry
+	}
+	if csvEntry == nil {
+		return fmt.Errorf("в архиве нет CSV файла")
+	}
+
+	// Размер из заголовка архива проверяем сразу; он может быть подделан, поэтому
+	// распакованные данные все равно ограничиваются при копировании
+	if csvEntry.UncompressedSize64 > uint64(maxCSVBytes()) {
+		return fmt.Errorf("распакованный файл больше %dMB", cfg().MaxCSVSizeMB)
+	}
+
+	reader, err := csvEntry.Open()
+	if err != nil {
+		return fmt.Errorf("архив поврежден: %v", err)
+	}
+	defer reader.Close()
+
+	return copyUnpacked(dst, reader)
+}
+
+// copyUnpacked копирует распакованные данные, прерываясь, если их больше MAX_CSV_SIZE_MB
+// (защита от архивов-бомб)
+func copyUnpacked(dst io.Writer, src io.Reader) error {
+	limit := maxCSVBytes()
+	written, err := io.Copy(dst, io.LimitReader(src, limit+1))
+	if err != nil {
+		return fmt.Errorf("архив поврежден: %v", err)
+	}
+	if written > limit {
+		return fmt.Errorf("распакованный файл больше %dMB", cfg().MaxCSVSizeMB)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sourceJobTestServer заглушка PIRELLI, которая запоминает имя и содержимое принятого файла
+func sourceJobTestServer(t *testing.T) (*httptest.Server, *string, *string) {
+	t.Helper()
+	var name, content string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() == "file" {
+				data, _ := io.ReadAll(part)
+				name, content = part.FileName(), string(data)
+			}
+		}
+		io.WriteString(w, `{"status": true, "code": 0, "message": "ok"}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, &name, &content
+}
+
+func TestSourceJobFormats(t *testing.T) {
+	const report = "sku,qty\n1,5\n"
+
+	dir := t.TempDir()
+	gzipPath := filepath.Join(dir, "stock.csv.gz")
+	file, err := os.Create(gzipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := gzip.NewWriter(file)
+	io.WriteString(writer, report)
+	writer.Close()
+	file.Close()
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		sendAs string
+	}{
+		{"csv", "stock.csv", "stock.csv"},
+		// CSV_FILE_PATH без расширения .csv отправлялся и до поддержки архивов
+		{"txt", "stock.txt", "stock.txt"},
+		{"без расширения", "export", "export"},
+		{"gzip", gzipPath, "stock.csv"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server, name, content := sourceJobTestServer(t)
+			setTestConfig(t, &Config{
+				BaseURL:              server.URL,
+				AuthLogin:            "5700097",
+				AuthToken:            strings.Repeat("a", 64),
+				AuditLogPath:         filepath.Join(dir, "audit.log"),
+				MaxCSVSizeMB:         1,
+				PirelliTLSMinVersion: "1.2",
+			})
+
+			path := tt.path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+				if err := os.WriteFile(path, []byte(report), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			job := newSourceJob(context.Background(), nil, "scheduled_upload", path)
+			runUploadJob(context.Background(), job)
+			<-job.done
+
+			if job.result == nil || !job.result.Success {
+				t.Fatalf("отправка %s не выполнена: %+v", tt.path, job.result)
+			}
+			if *name != tt.sendAs || *content != report {
+				t.Errorf("PIRELLI получил %q с содержимым %q", *name, *content)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// embeddedAssets шаблоны и статические файлы, встроенные в исполняемый файл
+//
+//go:embed templates static
+var embeddedAssets embed.FS
+
+var (
+	// pageTemplates HTML страницы по имени файла (form.html, token.html, ...)
+	pageTemplates map[string]*htmltemplate.Template
+	// notifyTemplates шаблоны уведомлений по ключу "<kind>/<событие>" (email/upload_success, ...)
+	notifyTemplates map[string]*template.Template
+	// staticFiles содержимое каталога static с учетом ASSETS_DIR
+	staticFiles fs.FS
+)
+
+// overlayFS отдает файл из каталога ASSETS_DIR, если он там есть, иначе встроенный
+type overlayFS struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+// Open открывает файл из каталога переопределений или встроенный
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if o.dir != nil {
+		if file, err := o.dir.Open(name); err == nil {
+			return file, nil
+		}
+	}
+	return o.base.Open(name)
+}
+
+// loadAssets разбирает встроенные шаблоны (или их замены из ASSETS_DIR) один раз при запуске
+func loadAssets() error {
+	assets := overlayFS{base: embeddedAssets}
+	if dir := cfg().AssetsDir; dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("ASSETS_DIR: каталог %s не найден", dir)
+		}
+		assets.dir = os.DirFS(dir)
+	}
+
+	pages := map[string]*htmltemplate.Template{}
+	names, _ := fs.Glob(embeddedAssets, "templates/*.html")
+	for _, name := range names {
+		content, err := fs.ReadFile(assets, name)
+		if err != nil {
+			return fmt.Errorf("не удалось прочитать %s: %v", name, err)
+		}
+		t, err := htmltemplate.New(name).Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("ошибка шаблона %s: %v", name, err)
+		}
+		pages[path.Base(name)] = t
+	}
+
+	notifications := map[string]*template.Template{}
+	names, _ = fs.Glob(embeddedAssets, "templates/*/*.tmpl")
+	for _, name := range names {
+		content, err := fs.ReadFile(assets, name)
+		if err != nil {
+			return fmt.Errorf("не удалось прочитать %s: %v", name, err)
+		}
+		key := strings.TrimSuffix(strings.TrimPrefix(name, "templates/"), ".tmpl")
+		t, err := template.New(key).Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("ошибка шаблона %s: %v", name, err)
+		}
+		notifications[key] = t
+	}
+
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		return err
+	}
+
+	pageTemplates = pages
+	notifyTemplates = notifications
+	staticFiles = static
+	return nil
+}
+
+// renderPage отображает HTML страницу из разобранных при запуске шаблонов
+func renderPage(w http.ResponseWriter, name string, data any) {
+	t, ok := pageTemplates[name]
+	if !ok {
+		http.Error(w, "Шаблон не найден: "+name, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		slog.Error("Ошибка отображения страницы", "template", name, "error", err)
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"sending-pirelli-stock/pirelli"
+)
+
+// Исходы действий в журнале аудита
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+	auditDenied  = "denied"
+	// auditRejected файл отклонен до отправки в PIRELLI
+	auditRejected = "rejected"
+)
+
+// AuditEntry запись журнала аудита. Каждая запись содержит хэш предыдущей,
+// поэтому изменение или удаление любой записи нарушает цепочку
+type AuditEntry struct {
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	// Actor проверенный инициатор: CN клиентского сертификата, адрес клиента или
+	// внутренний источник (scheduler, cli)
+	Actor string `json:"actor"`
+	// Login логин PIRELLI, от которого отправлялся файл (только для отправок)
+	Login string `json:"login,omitempty"`
+	// ClaimedActor инициатор из заголовка X-Actor или поля actor, не проверяется
+	ClaimedActor string `json:"claimed_actor,omitempty"`
+	IP           string `json:"ip,omitempty"`
+	Action       string `json:"action"`
+	Target       string `json:"target,omitempty"`
+	Checksum     string `json:"checksum,omitempty"`
+	Outcome      string `json:"outcome"`
+	Details      string `json:"details,omitempty"`
+	PrevHash     string `json:"prev_hash"`
+	Hash         string `json:"hash"`
+}
+
+// auditLog журнал аудита с поддержкой только добавления записей
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	lastSeq  int64
+	lastHash string
+	// size размер файла после последней записи этим процессом
+	size int64
+}
+
+// auditHead номер и хэш последней записи журнала, хранится в файле <журнал>.head.
+// По нему проверка замечает удаление последних записей, а подпись ключом
+// AUDIT_HMAC_KEY не дает переписать журнал целиком, не зная ключа
+type auditHead struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+var audit *auditLog
+
+// openAuditLog открывает журнал аудита и восстанавливает конец цепочки
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{path: path}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+
+	// Журнал, созданный до появления .head, подтверждаем по текущему концу цепочки
+	head, err := readAuditHead(path)
+	if err != nil {
+		return nil, err
+	}
+	if head == nil && a.lastSeq > 0 {
+		slog.Warn("Конец журнала аудита не был подтвержден, создается "+auditHeadPath(path), "seq", a.lastSeq)
+		if err := writeAuditHead(path, a.lastSeq, a.lastHash); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// load читает журнал и запоминает номер и хэш последней записи
+func (a *auditLog) load() error {
+	a.lastSeq, a.lastHash, a.size = 0, "", 0
+
+	file, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("не удалось открыть журнал аудита: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		a.size += int64(len(line)) + 1
+		if len(line) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("поврежденная запись журнала аудита после seq %d: %v", a.lastSeq, err)
+		}
+		a.lastSeq = entry.Seq
+		a.lastHash = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+	}
+
+	return nil
+}
+
+// append добавляет запись в журнал, дополняя ее номером и хэшами
+func (a *auditLog) append(entry AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть журнал аудита: %v", err)
+	}
+	defer file.Close()
+
+	// Журнал дописывают и другие процессы (например, report-server upload):
+	// запись и .head обновляются под блокировкой файла
+	if err := lockFile(file); err != nil {
+		return fmt.Errorf("не удалось заблокировать журнал аудита: %v", err)
+	}
+	defer unlockFile(file)
+
+	if info, err := file.Stat(); err != nil || info.Size() != a.size {
+		if err := a.load(); err != nil {
+			return err
+		}
+	}
+
+	entry.Seq = a.lastSeq + 1
+	entry.Time = entry.Time.UTC()
+	entry.PrevHash = a.lastHash
+	entry.Hash = auditEntryHash(entry)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации записи аудита: %v", err)
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("ошибка записи в журнал аудита: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("ошибка сброса журнала аудита на диск: %v", err)
+	}
+
+	a.lastSeq = entry.Seq
+	a.lastHash = entry.Hash
+	a.size += int64(len(line)) + 1
+	return writeAuditHead(a.path, a.lastSeq, a.lastHash)
+}
+
+// auditHeadPath путь к файлу конца журнала аудита
+func auditHeadPath(path string) string {
+	return path + ".head"
+}
+
+// auditHeadMAC подпись конца журнала ключом AUDIT_HMAC_KEY
+func auditHeadMAC(key string, seq int64, hash string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%d:%s", seq, hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// writeAuditHead сохраняет конец журнала; файл заменяется целиком, чтобы не оставить его наполовину записанным
+func writeAuditHead(path string, seq int64, hash string) error {
+	head := auditHead{Seq: seq, Hash: hash}
+	if key := cfg().AuditHMACKey; key != "" {
+		head.MAC = auditHeadMAC(key, seq, hash)
+	}
+	data, err := json.Marshal(head)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации конца журнала аудита: %v", err)
+	}
+
+	tmp := auditHeadPath(path) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("ошибка записи конца журнала аудита: %v", err)
+	}
+	if err := os.Rename(tmp, auditHeadPath(path)); err != nil {
+		return fmt.Errorf("ошибка записи конца журнала аудита: %v", err)
+	}
+	return nil
+}
+
+// readAuditHead читает конец журнала; nil, если файла еще нет
+func readAuditHead(path string) (*auditHead, error) {
+	data, err := os.ReadFile(auditHeadPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать конец журнала аудита: %v", err)
+	}
+	var head auditHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("поврежден файл %s: %v", auditHeadPath(path), err)
+	}
+	return &head, nil
+}
+
+// auditEntryHash вычисляет хэш записи без поля Hash
+func auditEntryHash(entry AuditEntry) string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyAuditLog проверяет целостность цепочки и ее конец по head и возвращает число
+// проверенных записей. head - содержимое файла .head, nil, если его нет
+func verifyAuditLog(r io.Reader, head *auditHead) (int64, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var (
+		count    int64
+		prevHash string
+		prevSeq  int64
+	)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		count++
+
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return count, fmt.Errorf("строка %d: запись не читается: %v", count, err)
+		}
+		if entry.Seq != prevSeq+1 {
+			return count, fmt.Errorf("запись seq %d: ожидался seq %d (записи удалены или переставлены)", entry.Seq, prevSeq+1)
+		}
+		if entry.PrevHash != prevHash {
+			return count, fmt.Errorf("запись seq %d: prev_hash не совпадает с хэшем предыдущей записи", entry.Seq)
+		}
+		if expected := auditEntryHash(entry); entry.Hash != expected {
+			return count, fmt.Errorf("запись seq %d: хэш не совпадает (запись изменена)", entry.Seq)
+		}
+
+		prevSeq = entry.Seq
+		prevHash = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+	}
+
+	if head == nil {
+		if count > 0 {
+			return count, fmt.Errorf("нет файла .head: конец журнала не подтвержден")
+		}
+		return count, nil
+	}
+	if head.Seq != prevSeq || head.Hash != prevHash {
+		return count, fmt.Errorf("последняя запись seq %d, а в .head seq %d (записи в конце удалены или журнал подменен)", prevSeq, head.Seq)
+	}
+	if key := cfg().AuditHMACKey; key != "" && !hmac.Equal([]byte(head.MAC), []byte(auditHeadMAC(key, head.Seq, head.Hash))) {
+		return count, fmt.Errorf("подпись .head не совпадает с AUDIT_HMAC_KEY (журнал переписан или подписан другим ключом)")
+	}
+	return count, nil
+}
+
+// verifyAuditLogFile проверяет журнал аудита по пути
+func verifyAuditLogFile(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("не удалось открыть журнал аудита: %v", err)
+	}
+	defer file.Close()
+
+	head, err := readAuditHead(path)
+	if err != nil {
+		return 0, err
+	}
+	return verifyAuditLog(file, head)
+}
+
+// recordAudit добавляет запись о действии в журнал аудита
+func recordAudit(r *http.Request, action, target, checksum, outcome, details string) {
+	entry := AuditEntry{
+		Time:     time.Now(),
+		Actor:    "system",
+		Action:   action,
+		Target:   target,
+		Checksum: checksum,
+		Outcome:  outcome,
+		Details:  details,
+	}
+
+	if r != nil {
+		entry.Actor = requestActor(r)
+		entry.ClaimedActor = claimedActor(r)
+		entry.IP = clientIP(r)
+	}
+
+	writeAudit(entry)
+}
+
+// recordSchedulerAudit добавляет запись о действии планировщика
+func recordSchedulerAudit(action, target, checksum, outcome, details string) {
+	recordActorAudit("scheduler", action, target, checksum, outcome, details)
+}
+
+// recordActorAudit добавляет запись о действии вне HTTP запроса
+func recordActorAudit(actor, action, target, checksum, outcome, details string) {
+	recordLoginAudit(actor, "", action, target, checksum, outcome, details)
+}
+
+// recordLoginAudit добавляет запись об отправке вне HTTP запроса от логина PIRELLI login
+func recordLoginAudit(actor, login, action, target, checksum, outcome, details string) {
+	writeAudit(AuditEntry{
+		Time:     time.Now(),
+		Actor:    actor,
+		Login:    login,
+		Action:   action,
+		Target:   target,
+		Checksum: checksum,
+		Outcome:  outcome,
+		Details:  details,
+	})
+}
+
+// writeAudit записывает запись, сообщая об ошибке в лог
+func writeAudit(entry AuditEntry) {
+	if audit == nil {
+		return
+	}
+	if err := audit.append(entry); err != nil {
+		slog.Error("Ошибка журнала аудита", "error", err, "action", entry.Action)
+	}
+}
+
+// pirelliOutcome возвращает исход отправки по ответу PIRELLI
+func pirelliOutcome(response *pirelli.Response) string {
+	if response.Status {
+		return auditSuccess
+	}
+	return auditFailure
+}
+
+// requestActor определяет, кто выполняет действие: CN проверенного клиентского
+// сертификата, иначе адрес клиента. Пароль администратора общий и инициатора не называет
+func requestActor(r *http.Request) string {
+	if name := clientCertName(r); name != "" {
+		return "cert:" + name
+	}
+	return "ip:" + clientIP(r)
+}
+
+// claimedActor инициатор, которым клиент назвал себя в заголовке X-Actor или поле actor
+func claimedActor(r *http.Request) string {
+	actor := strings.TrimSpace(r.Header.Get("X-Actor"))
+	if actor == "" {
+		actor = strings.TrimSpace(r.FormValue("actor"))
+	}
+	return actor
+}
+
+// clientIP возвращает адрес клиента без порта
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// fileSHA256 вычисляет контрольную сумму файла
+func fileSHA256(filePath string) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	return readerSHA256(file)
+}
+
+// readerSHA256 вычисляет контрольную сумму содержимого и возвращает позицию чтения в начало
+func readerSHA256(file io.ReadSeeker) string {
+	defer file.Seek(0, io.SeekStart)
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// handleAuditExport отдает журнал аудита в формате JSON Lines
+func handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "audit_export", cfg().AuditLogPath, "", auditDenied, "неверный пароль")
+		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+		return
+	}
+
+	// Держим блокировку, чтобы не отдать наполовину записанную строку
+	audit.mu.Lock()
+	content, err := os.ReadFile(audit.path)
+	head, headErr := readAuditHead(audit.path)
+	audit.mu.Unlock()
+	if (err != nil && !os.IsNotExist(err)) || headErr != nil {
+		http.Error(w, "Ошибка чтения журнала аудита", http.StatusInternalServerError)
+		return
+	}
+
+	verified := "ok"
+	if _, verifyErr := verifyAuditLog(strings.NewReader(string(content)), head); verifyErr != nil {
+		loggerFrom(r.Context()).Error("Журнал аудита не прошел проверку", "error", verifyErr)
+		verified = "failed"
+	}
+
+	recordAudit(r, "audit_export", cfg().AuditLogPath, "", auditSuccess, "")
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.log"`)
+	w.Header().Set("X-Audit-Verify", verified)
+	w.Write(content)
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"sending-pirelli-stock/pirelli"
+)
+
+// cliUsage справка по подкомандам
+const cliUsage = `Использование: report-server [команда] [параметры]
+
+Команды:
+  serve                   запустить сервер (по умолчанию)
+  upload <файл>           проверить файл и отправить его в PIRELLI
+  validate <файл>         проверить файл без отправки
+  history [-n 20]         последние отправки из журнала аудита
+  next-run                время следующей автоматической отправки
+  check-config            проверить конфигурацию
+  verify-audit [путь]     проверить целостность журнала аудита
+
+Параметр --json включает вывод в формате JSON.
+Код выхода 0 - успех, 1 - ошибка, 2 - неверные параметры.
+`
+
+// runCLI выполняет подкоманду и возвращает код выхода
+func runCLI(args []string) int {
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve()
+		return 0
+	case "upload":
+		return cliUpload(args)
+	case "validate":
+		return cliValidate(args)
+	case "history":
+		return cliHistory(args)
+	case "next-run":
+		return cliNextRun(args)
+	case "check-config":
+		return cliCheckConfig(args)
+	case "verify-audit":
+		return cliVerifyAudit(args)
+	case "help", "-h", "--help":
+		fmt.Print(cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Неизвестная команда %q\n\n%s", command, cliUsage)
+		return 2
+	}
+}
+
+// parseCLIFlags разбирает флаги в любом месте командной строки и возвращает позиционные аргументы
+func parseCLIFlags(fs *flag.FlagSet, args []string) ([]string, bool) {
+	fs.SetOutput(os.Stderr)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, false
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, true
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// printJSON выводит результат команды в формате JSON
+func printJSON(value any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// cliActor возвращает инициатора действий из командной строки для журнала аудита
+func cliActor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// CLIUploadResult результат команды upload
+type CLIUploadResult struct {
+	Success  bool              `json:"success"`
+	File     string            `json:"file"`
+	SentAs   string            `json:"sent_as,omitempty"`
+	Rows     int               `json:"rows"`
+	Response *pirelli.Response `json:"response,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	// PirelliError класс ошибки PIRELLI и сырой ответ для диагностики
+	PirelliError *pirelli.Error `json:"pirelli_error,omitempty"`
+}
+
+// cliUpload проверяет файл и отправляет его в PIRELLI
+func cliUpload(args []string) int {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "вывод в формате JSON")
+	force := fs.Bool("force", false, "отправить, даже если такой же файл уже отправлялся в DEDUPE_WINDOW")
+	positional, ok := parseCLIFlags(fs, args)
+	if !ok || len(positional) != 1 {
+		fmt.Fprint(os.Stderr, "Использование: report-server upload <файл> [--json] [--force]\n")
+		return 2
+	}
+
+	var err error
+	audit, err = openAuditLog(cfg().AuditLogPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка журнала аудита: %v\n", err)
+		return 1
+	}
+
+	path := positional[0]
+	ctx := withTrigger(withLogger(context.Background(), slog.Default().With("request_id", newRequestID())), triggerCLI)
+	result := CLIUploadResult{File: path}
+	actor := cliActor()
+	checksum := fileSHA256(path)
+
+	// Завершаемся только после отправки уведомлений
+	defer notifyWG.Wait()
+
+	csvPath, cleanup, err := prepareUploadPath(ctx, path)
+	if err != nil {
+		result.Error = err.Error()
+		recordActorAudit(actor, "cli_upload", filepath.Base(path), checksum, auditRejected, "проверка файла: "+err.Error())
+		notifyValidationRejected(ctx, filepath.Base(path), err.Error())
+		return printUploadResult(result, *jsonOutput)
+	}
+	defer cleanup()
+	result.Rows = countCSVRows(csvPath)
+	// В журнал и проверку повтора идет контрольная сумма отправляемого CSV
+	checksum = fileSHA256(csvPath)
+
+	// Сервер может в это же время отправлять отчет этого логина: ждем его завершения
+	creds := currentCredentials()
+	unlock, err := lockAccount(ctx, creds.Login, func() {
+		fmt.Fprintf(os.Stderr, "Ожидание завершения другой отправки %s\n", creds.Login)
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return printUploadResult(result, *jsonOutput)
+	}
+	defer unlock()
+
+	if !*force {
+		if err := findDuplicateUpload(creds.Login, checksum); err != nil {
+			result.Error = err.Error()
+			recordLoginAudit(actor, creds.Login, "cli_upload", filepath.Base(path), checksum, auditRejected, "повторная отправка: "+err.Error())
+			return printUploadResult(result, *jsonOutput)
+		}
+	}
+
+	result.SentAs = generatePirelliFilename(creds.Login)
+	response, err := uploadFileToPirelli(ctx, creds, csvPath, result.SentAs)
+	result.Response = response
+	details := "исходный файл " + filepath.Base(path)
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+		if errors.As(err, &result.PirelliError) && pirelliHint(result.PirelliError) != "" {
+			result.Error += ". " + pirelliHint(result.PirelliError)
+		}
+		recordLoginAudit(actor, creds.Login, "cli_upload", result.SentAs, checksum, auditFailure, details+": "+err.Error())
+	default:
+		result.Success = true
+		recordLoginAudit(actor, creds.Login, "cli_upload", result.SentAs, checksum, auditSuccess, details+": "+response.Message)
+	}
+
+	return printUploadResult(result, *jsonOutput)
+}
+
+// printUploadResult выводит результат отправки и возвращает код выхода
+func printUploadResult(result CLIUploadResult, jsonOutput bool) int {
+	if jsonOutput {
+		printJSON(result)
+	} else if result.Success {
+		fmt.Printf("Файл %s отправлен в PIRELLI как %s (строк: %d): %s\n", result.File, result.SentAs, result.Rows, result.Response.Message)
+	} else {
+		fmt.Printf("Файл %s не отправлен: %s\n", result.File, result.Error)
+	}
+
+	if !result.Success {
+		return 1
+	}
+	return 0
+}
+
+// prepareUploadPath проверяет формат файла, распаковывает .csv.gz и .zip и проверяет
+// содержимое CSV перед отправкой. Возвращает путь к CSV и функцию удаления временного файла
+func prepareUploadPath(ctx context.Context, path string) (string, func(), error) {
+	if uploadFormat(path) == "" {
+		observeValidationFailure("extension")
+		return "", nil, fmt.Errorf("можно загружать только CSV файлы (.csv, .csv.gz или .zip с одним CSV)")
+	}
+
+	csvPath, cleanup, err := prepareCSV(path, path)
+	if err != nil {
+		observeValidationFailure("archive")
+		return "", nil, err
+	}
+	if err := validateCSVFileFromPath(ctx, csvPath); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return csvPath, cleanup, nil
+}
+
+// CLIValidateResult результат команды validate
+type CLIValidateResult struct {
+	File  string `json:"file"`
+	Valid bool   `json:"valid"`
+	Rows  int    `json:"rows"`
+	Error string `json:"error,omitempty"`
+}
+
+// cliValidate проверяет файл без отправки в PIRELLI
+func cliValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "вывод в формате JSON")
+	positional, ok := parseCLIFlags(fs, args)
+	if !ok || len(positional) != 1 {
+		fmt.Fprint(os.Stderr, "Использование: report-server validate <файл> [--json]\n")
+		return 2
+	}
+
+	path := positional[0]
+	result := CLIValidateResult{File: path, Valid: true}
+	csvPath, cleanup, err := prepareUploadPath(context.Background(), path)
+	if err != nil {
+		result.Valid = false
+		result.Error = err.Error()
+	} else {
+		result.Rows = countCSVRows(csvPath)
+		cleanup()
+	}
+
+	if *jsonOutput {
+		printJSON(result)
+	} else if result.Valid {
+		fmt.Printf("Файл %s прошел проверку (строк: %d)\n", path, result.Rows)
+	} else {
+		fmt.Printf("Файл %s не прошел проверку: %s\n", path, result.Error)
+	}
+
+	if !result.Valid {
+		return 1
+	}
+	return 0
+}
+
+// cliHistory выводит последние отправки из журнала аудита
+func cliHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "вывод в формате JSON")
+	limit := fs.Int("n", 20, "число записей")
+	if _, ok := parseCLIFlags(fs, args); !ok {
+		return 2
+	}
+
+	entries, err := readUploadHistory(cfg().AuditLogPath, *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка чтения журнала аудита: %v\n", err)
+		return 1
+	}
+
+	if *jsonOutput {
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+		printJSON(entries)
+		return 0
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("Отправок еще не было")
+		return 0
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s  %-9s  %-8s  %-16s  %s  %s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"),
+			uploadActionTrigger(entry.Action), entry.Outcome, entry.Actor, entry.Target, entry.Details)
+	}
+	return 0
+}
+
+// readUploadHistory возвращает последние limit записей об отправках из журнала аудита
+func readUploadHistory(path string, limit int) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || !isUploadAction(entry.Action) {
+			continue
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// cliNextRun выводит время следующей автоматической отправки
+func cliNextRun(args []string) int {
+	fs := flag.NewFlagSet("next-run", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "вывод в формате JSON")
+	if _, ok := parseCLIFlags(fs, args); !ok {
+		return 2
+	}
+
+	result := struct {
+		Enabled    bool       `json:"enabled"`
+		UploadTime string     `json:"upload_time"`
+		UploadDay  int        `json:"upload_day"`
+		NextRun    *time.Time `json:"next_run,omitempty"`
+		Error      string     `json:"error,omitempty"`
+	}{
+		Enabled:    cfg().UploadTime != "",
+		UploadTime: cfg().UploadTime,
+		UploadDay:  cfg().UploadDay,
+	}
+
+	if result.Enabled {
+		next, err := nextScheduledRun(time.Now())
+		if err != nil {
+			result.Error = "UPLOAD_TIME должен быть в формате ЧЧ:ММ"
+		} else {
+			result.NextRun = &next
+		}
+	}
+
+	switch {
+	case *jsonOutput:
+		printJSON(result)
+	case !result.Enabled:
+		fmt.Println("Автоматическая отправка выключена")
+	case result.Error != "":
+		fmt.Println(result.Error)
+	default:
+		fmt.Println(result.NextRun.Format("2006-01-02 15:04:05"))
+	}
+
+	if result.Error != "" {
+		return 1
+	}
+	return 0
+}
+
+// cliCheckConfig проверяет конфигурацию и возвращает 1 при ошибках
+func cliCheckConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "вывод в формате JSON")
+	if _, ok := parseCLIFlags(fs, args); !ok {
+		return 2
+	}
+
+	snapshot := currentConfig.Load()
+	problems := snapshot.storedProblems()
+	if *jsonOutput {
+		printJSON(struct {
+			ConfigFile string        `json:"config_file,omitempty"`
+			Valid      bool          `json:"valid"`
+			Problems   []string      `json:"problems"`
+			Values     []ConfigValue `json:"values"`
+		}{snapshot.File, len(problems) == 0, problems, snapshot.effectiveValues()})
+	} else if len(problems) == 0 {
+		fmt.Println("Конфигурация в порядке")
+	} else {
+		for _, problem := range problems {
+			fmt.Println("-", problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// cliVerifyAudit проверяет целостность журнала аудита
+func cliVerifyAudit(args []string) int {
+	fs := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "вывод в формате JSON")
+	positional, ok := parseCLIFlags(fs, args)
+	if !ok {
+		return 2
+	}
+
+	path := cfg().AuditLogPath
+	if len(positional) > 0 {
+		path = positional[0]
+	}
+
+	count, err := verifyAuditLogFile(path)
+	if *jsonOutput {
+		result := struct {
+			Path    string `json:"path"`
+			Valid   bool   `json:"valid"`
+			Entries int64  `json:"entries"`
+			Error   string `json:"error,omitempty"`
+		}{Path: path, Valid: err == nil, Entries: count}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		printJSON(result)
+	} else if err != nil {
+		fmt.Printf("Журнал аудита %s поврежден: %v\n", path, err)
+	} else {
+		fmt.Printf("Журнал аудита %s цел, записей: %d\n", path, count)
+	}
+
+	if err != nil {
+		return 1
+	}
+	return 0
+}
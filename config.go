@@ -0,0 +1,714 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+
+	"sending-pirelli-stock/pirelli"
+)
+
+// Config структура для конфигурации
+type Config struct {
+	BaseURL       string
+	CompanyName   string
+	AuthLogin     string
+	AuthToken     string
+	ServerPort    string
+	AdminPassword string
+	UploadTime    string
+	UploadDay     int
+	CSVFilePath   string
+	VerifyAction  string
+	ListAction    string
+	AuditLogPath  string
+	// AuditHMACKey ключ подписи конца журнала аудита
+	AuditHMACKey string
+
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSClientCAFile       string
+	TLSClientCertRequired bool
+	TLSSelfSignedHosts    []string
+	HTTPRedirectPort      string
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	DrainDelay        time.Duration
+	ShutdownTimeout   time.Duration
+
+	// JobWorkers число одновременных отправок, JobQueueSize - сколько заданий ждут в очереди
+	JobWorkers   int
+	JobQueueSize int
+
+	LogLevel      string
+	LogFormat     string
+	LogFile       string
+	LogMaxSizeMB  int
+	LogMaxBackups int
+
+	SourceMaxAge  time.Duration
+	DiskMinFreeMB int
+	// MaxUploadSizeMB максимальный размер загружаемого файла, MaxCSVSizeMB - CSV после распаковки
+	MaxUploadSizeMB int
+	MaxCSVSizeMB    int
+	// DedupeWindow сколько не отправлять повторно файл с той же контрольной суммой (0 - выключено)
+	DedupeWindow time.Duration
+	// ReconcileInterval как часто сверять историю отправок со списком PIRELLI (0 - выключено),
+	// ReconcileWindow - за какой период
+	ReconcileInterval time.Duration
+	ReconcileWindow   time.Duration
+	// PirelliTimezone часовой пояс времени в списке файлов PIRELLI
+	PirelliTimezone *time.Location
+	// Подключение к PIRELLI: прокси, дополнительные CA, клиентский сертификат и таймауты
+	PirelliProxyURL       string
+	PirelliCAFiles        []string
+	PirelliClientCertFile string
+	PirelliClientKeyFile  string
+	PirelliTLSMinVersion  string
+	PirelliConnectTimeout time.Duration
+	PirelliReadTimeout    time.Duration
+	PirelliTimeout        time.Duration
+	// PirelliCodes описания известных кодов ответа PIRELLI из PIRELLI_CODES
+	PirelliCodes map[string]pirelli.Code
+
+	SMTPHost        string
+	SMTPPort        string
+	SMTPUsername    string
+	SMTPPassword    string
+	SMTPFrom        string
+	SMTPImplicitTLS bool
+	NotifyEmailTo   []string
+	// NotifyEmailToByLogin получатели по логину из NOTIFY_EMAIL_TO_<ЛОГИН>
+	NotifyEmailToByLogin map[string][]string
+
+	TelegramBotToken string
+	TelegramChatIDs  []string
+	TelegramAPIURL   string
+	WebhookURL       string
+	NotifyRoutes     map[string][]string
+	NotifyRateLimit  time.Duration
+
+	ConfigWatchInterval time.Duration
+	// AssetsDir каталог с заменами встроенных шаблонов и статических файлов
+	AssetsDir string
+}
+
+// configSnapshot действующая конфигурация и сведения о ее загрузке. Опубликованный
+// снимок не изменяется: перезагрузка и ротация токена публикуют новый, поэтому
+// начатая отправка работает с тем снимком, который получила в начале
+type configSnapshot struct {
+	Config   *Config
+	File     string
+	Values   []ConfigValue
+	Problems []string
+	// ConnectionProblems ошибки подключения к PIRELLI, найденные при загрузке снимка (до
+	// публикации); проверка состояния берет их отсюда, не читая файлы сертификатов
+	ConnectionProblems []string
+	LoadedAt           time.Time
+}
+
+var (
+	currentConfig atomic.Pointer[configSnapshot]
+
+	// configMu упорядочивает публикацию снимков (перезагрузка, ротация токена)
+	configMu sync.Mutex
+)
+
+// cfg возвращает действующую конфигурацию; изменять ее нельзя
+func cfg() *Config {
+	return currentConfig.Load().Config
+}
+
+// Источники значений конфигурации
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceDotenv  = ".env"
+	sourceEnv     = "env"
+)
+
+// defaultConfigFile файл конфигурации, который читается, если CONFIG_FILE не задан
+const defaultConfigFile = "config.yaml"
+
+// secretConfigKeys параметры, значения которых маскируются в /api/config
+var secretConfigKeys = map[string]bool{
+	"AUTH_TOKEN":         true,
+	"ADMIN_PASSWORD":     true,
+	"SMTP_PASSWORD":      true,
+	"TELEGRAM_BOT_TOKEN": true,
+	"WEBHOOK_URL":        true,
+	"PIRELLI_PROXY_URL":  true,
+	"AUDIT_HMAC_KEY":     true,
+}
+
+// ConfigValue значение параметра конфигурации и его источник
+type ConfigValue struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// configLoader читает параметры с приоритетом: значение по умолчанию < файл < .env < переменные окружения
+type configLoader struct {
+	fileName string
+	file     map[string]string
+	dotenv   map[string]string
+	values   []ConfigValue
+	problems []string
+}
+
+// loadConfig загружает конфигурацию из файла, .env и переменных окружения
+func loadConfig() error {
+	snapshot, err := readConfig(nil)
+	if err != nil {
+		return err
+	}
+
+	snapshot.ConnectionProblems = pirelliConnectionProblems(snapshot.Config)
+	currentConfig.Store(snapshot)
+	return nil
+}
+
+// readConfig читает конфигурацию в новый снимок, не изменяя текущую.
+// dotenvOverrides подменяют значения из .env (для проверки настроек до сохранения)
+func readConfig(dotenvOverrides map[string]string) (*configSnapshot, error) {
+	l := &configLoader{file: map[string]string{}}
+
+	// .env не обязателен
+	l.dotenv, _ = godotenv.Read(envFilePath)
+	if l.dotenv == nil {
+		l.dotenv = map[string]string{}
+	}
+	for key, value := range dotenvOverrides {
+		l.dotenv[key] = value
+	}
+
+	fileName := l.raw("CONFIG_FILE")
+	explicit := fileName != ""
+	if !explicit {
+		fileName = defaultConfigFile
+	}
+	if err := l.readFile(fileName, explicit); err != nil {
+		return nil, err
+	}
+
+	loaded := Config{
+		BaseURL:       l.str("BASE_URL", "https://reports.pirelli.ru/local/templates/dealer/ajax/api.php"),
+		CompanyName:   l.str("COMPANY_NAME", "SEMISOTNOV"),
+		AuthLogin:     l.str("AUTH_LOGIN", "5700097"),
+		AuthToken:     l.str("AUTH_TOKEN", "c9f5f90185a7eae42557cf298188614144bcfcfd6ac806aa6fefb63c3e814456"),
+		ServerPort:    l.str("SERVER_PORT", "8080"),
+		AdminPassword: l.str("ADMIN_PASSWORD", "admin123"),
+		UploadTime:    l.str("UPLOAD_TIME", "09:00"),
+		UploadDay:     l.integer("UPLOAD_DAY", 1),
+		CSVFilePath:   l.str("CSV_FILE_PATH", "./report.csv"),
+		VerifyAction:  l.str("VERIFY_ACTION", "list"),
+		ListAction:    l.str("LIST_ACTION", "list"),
+		AuditLogPath:  l.str("AUDIT_LOG_PATH", "./audit.log"),
+		AuditHMACKey:  l.str("AUDIT_HMAC_KEY", ""),
+
+		TLSCertFile:           l.str("TLS_CERT_FILE", ""),
+		TLSKeyFile:            l.str("TLS_KEY_FILE", ""),
+		TLSClientCAFile:       l.str("TLS_CLIENT_CA_FILE", ""),
+		TLSClientCertRequired: l.boolean("TLS_CLIENT_CERT_REQUIRED", false),
+		TLSSelfSignedHosts:    l.list("TLS_SELF_SIGNED_HOSTS", defaultSelfSignedHosts()),
+		HTTPRedirectPort:      l.str("HTTP_REDIRECT_PORT", ""),
+
+		ReadHeaderTimeout: l.duration("READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       l.duration("READ_TIMEOUT", 60*time.Second),
+		WriteTimeout:      l.duration("WRITE_TIMEOUT", 90*time.Second),
+		IdleTimeout:       l.duration("IDLE_TIMEOUT", 120*time.Second),
+		MaxHeaderBytes:    l.integer("MAX_HEADER_BYTES", 64<<10),
+		DrainDelay:        l.duration("DRAIN_DELAY", 5*time.Second),
+		ShutdownTimeout:   l.duration("SHUTDOWN_TIMEOUT", 60*time.Second),
+
+		JobWorkers:   l.integer("JOB_WORKERS", 2),
+		JobQueueSize: l.integer("JOB_QUEUE_SIZE", 100),
+
+		LogLevel:      l.str("LOG_LEVEL", "info"),
+		LogFormat:     l.str("LOG_FORMAT", "text"),
+		LogFile:       l.str("LOG_FILE", ""),
+		LogMaxSizeMB:  l.integer("LOG_MAX_SIZE_MB", 50),
+		LogMaxBackups: l.integer("LOG_MAX_BACKUPS", 5),
+
+		SourceMaxAge:  l.duration("SOURCE_MAX_AGE", 7*24*time.Hour),
+		DiskMinFreeMB: l.integer("DISK_MIN_FREE_MB", 100),
+		DedupeWindow:  l.duration("DEDUPE_WINDOW", 24*time.Hour),
+
+		MaxUploadSizeMB: l.integer("MAX_UPLOAD_SIZE_MB", 10),
+		MaxCSVSizeMB:    l.integer("MAX_CSV_SIZE_MB", 100),
+
+		PirelliCodes: pirelli.ParseCodes(l.str("PIRELLI_CODES", "")),
+
+		PirelliProxyURL:       l.str("PIRELLI_PROXY_URL", ""),
+		PirelliCAFiles:        l.list("PIRELLI_CA_FILES", ""),
+		PirelliClientCertFile: l.str("PIRELLI_CLIENT_CERT_FILE", ""),
+		PirelliClientKeyFile:  l.str("PIRELLI_CLIENT_KEY_FILE", ""),
+		PirelliTLSMinVersion:  l.str("PIRELLI_TLS_MIN_VERSION", "1.2"),
+		PirelliConnectTimeout: l.duration("PIRELLI_CONNECT_TIMEOUT", 10*time.Second),
+		PirelliReadTimeout:    l.duration("PIRELLI_READ_TIMEOUT", 30*time.Second),
+		PirelliTimeout:        l.duration("PIRELLI_TIMEOUT", 30*time.Second),
+
+		ReconcileInterval: l.duration("RECONCILE_INTERVAL", 6*time.Hour),
+		ReconcileWindow:   l.duration("RECONCILE_WINDOW", 7*24*time.Hour),
+		PirelliTimezone:   l.location("PIRELLI_TIMEZONE", "Local"),
+
+		SMTPHost:             l.str("SMTP_HOST", ""),
+		SMTPPort:             l.str("SMTP_PORT", "587"),
+		SMTPUsername:         l.str("SMTP_USERNAME", ""),
+		SMTPPassword:         l.str("SMTP_PASSWORD", ""),
+		SMTPFrom:             l.str("SMTP_FROM", "pirelli-reports@localhost"),
+		SMTPImplicitTLS:      l.boolean("SMTP_IMPLICIT_TLS", false),
+		NotifyEmailTo:        l.list("NOTIFY_EMAIL_TO", ""),
+		NotifyEmailToByLogin: map[string][]string{},
+
+		TelegramBotToken: l.str("TELEGRAM_BOT_TOKEN", ""),
+		TelegramChatIDs:  l.list("TELEGRAM_CHAT_ID", ""),
+		TelegramAPIURL:   l.str("TELEGRAM_API_URL", "https://api.telegram.org"),
+		WebhookURL:       l.str("WEBHOOK_URL", ""),
+		NotifyRoutes:     parseNotifyRoutes(l.str("NOTIFY_ROUTES", "")),
+		NotifyRateLimit:  l.duration("NOTIFY_RATE_LIMIT", 10*time.Minute),
+
+		ConfigWatchInterval: l.duration("CONFIG_WATCH_INTERVAL", 5*time.Second),
+		AssetsDir:           l.str("ASSETS_DIR", ""),
+	}
+
+	const byLoginPrefix = "NOTIFY_EMAIL_TO_"
+	for _, key := range l.prefixedKeys(byLoginPrefix) {
+		loaded.NotifyEmailToByLogin[strings.TrimPrefix(key, byLoginPrefix)] = l.list(key, "")
+	}
+
+	l.checkUnknownFileKeys()
+	return &configSnapshot{
+		Config:   &loaded,
+		File:     l.fileName,
+		Values:   l.values,
+		Problems: l.problems,
+		LoadedAt: time.Now(),
+	}, nil
+}
+
+// readFile читает YAML файл конфигурации; отсутствие файла по умолчанию не ошибка
+func (l *configLoader) readFile(fileName string, explicit bool) error {
+	content, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("не удалось прочитать файл конфигурации: %v", err)
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("ошибка разбора %s: %v", fileName, err)
+	}
+
+	for key, value := range values {
+		l.file[strings.ToUpper(key)] = yamlValueString(value)
+	}
+	l.fileName = fileName
+	return nil
+}
+
+// yamlValueString приводит значение YAML к строке в формате переменных окружения:
+// списки через запятую, словари как "ключ=значение;..."
+func yamlValueString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, yamlValueString(item))
+		}
+		return strings.Join(items, ",")
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		rules := make([]string, 0, len(keys))
+		for _, key := range keys {
+			rules = append(rules, key+"="+yamlValueString(v[key]))
+		}
+		return strings.Join(rules, ";")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// raw возвращает значение без учета источника и без записи в список параметров
+func (l *configLoader) raw(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return l.dotenv[key]
+}
+
+// lookup возвращает значение параметра с наибольшим приоритетом и запоминает его источник
+func (l *configLoader) lookup(key, defaultValue string) string {
+	value, source := defaultValue, sourceDefault
+	if v, ok := l.file[key]; ok {
+		value, source = v, sourceFile
+	}
+	// Пустое значение в .env действует только для параметров, которые можно очистить
+	// на странице настроек; для остальных остается значение из файла или по умолчанию
+	if v, ok := l.dotenv[key]; ok && (v != "" || optionalSetting(key)) {
+		value, source = v, sourceDotenv
+	}
+	// Значения из .env не попадают в окружение, поэтому здесь только внешние переменные
+	if v := os.Getenv(key); v != "" {
+		value, source = v, sourceEnv
+	}
+
+	l.values = append(l.values, ConfigValue{Key: key, Value: value, Source: source})
+	return value
+}
+
+// problem запоминает ошибку разбора значения
+func (l *configLoader) problem(key, expected, value string) {
+	l.problems = append(l.problems, fmt.Sprintf("%s: ожидается %s, получено %q", key, expected, value))
+}
+
+// str возвращает строковый параметр
+func (l *configLoader) str(key, defaultValue string) string {
+	return l.lookup(key, defaultValue)
+}
+
+// integer возвращает целочисленный параметр
+func (l *configLoader) integer(key string, defaultValue int) int {
+	value := l.lookup(key, strconv.Itoa(defaultValue))
+	result, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		l.problem(key, "целое число", value)
+		return defaultValue
+	}
+	return result
+}
+
+// duration возвращает параметр-длительность (например, 30s, 10m, 168h)
+func (l *configLoader) duration(key string, defaultValue time.Duration) time.Duration {
+	value := l.lookup(key, defaultValue.String())
+	result, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		l.problem(key, "длительность вида 30s, 10m или 2h", value)
+		return defaultValue
+	}
+	return result
+}
+
+// location возвращает параметр-часовой пояс: имя IANA (Europe/Moscow), UTC или Local
+func (l *configLoader) location(key, defaultValue string) *time.Location {
+	value := l.lookup(key, defaultValue)
+	result, err := time.LoadLocation(strings.TrimSpace(value))
+	if err != nil {
+		l.problem(key, "часовой пояс вида Europe/Moscow, UTC или Local", value)
+		return time.Local
+	}
+	return result
+}
+
+// boolean возвращает логический параметр
+func (l *configLoader) boolean(key string, defaultValue bool) bool {
+	value := l.lookup(key, strconv.FormatBool(defaultValue))
+	result, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		l.problem(key, "true или false", value)
+		return defaultValue
+	}
+	return result
+}
+
+// list возвращает параметр-список через запятую
+func (l *configLoader) list(key, defaultValue string) []string {
+	return splitList(l.lookup(key, defaultValue))
+}
+
+// prefixedKeys возвращает имена параметров с префиксом из всех источников
+func (l *configLoader) prefixedKeys(prefix string) []string {
+	seen := map[string]bool{}
+	for key := range l.file {
+		seen[key] = true
+	}
+	for key := range l.dotenv {
+		seen[key] = true
+	}
+	for _, item := range os.Environ() {
+		key, _, _ := strings.Cut(item, "=")
+		seen[key] = true
+	}
+
+	var keys []string
+	for key := range seen {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkUnknownFileKeys сообщает о параметрах в файле, которые не используются (опечатки)
+func (l *configLoader) checkUnknownFileKeys() {
+	known := map[string]bool{}
+	for _, value := range l.values {
+		known[value.Key] = true
+	}
+
+	var unknown []string
+	for key := range l.file {
+		if !known[key] {
+			unknown = append(unknown, strings.ToLower(key))
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		l.problems = append(l.problems, fmt.Sprintf("%s: неизвестный параметр %q", l.fileName, key))
+	}
+}
+
+// validate возвращает все ошибки конфигурации: разбор значений, значения, доступность путей
+// и подключение к PIRELLI, проверяя его заново. Снимок не изменяет
+func (s *configSnapshot) validate() []string {
+	return s.problemsWith(pirelliConnectionProblems(s.Config))
+}
+
+// storedProblems возвращает ошибки конфигурации как validate, но ошибки подключения к PIRELLI
+// берет из ConnectionProblems, не читая файлы сертификатов
+func (s *configSnapshot) storedProblems() []string {
+	return s.problemsWith(s.ConnectionProblems)
+}
+
+// problemsWith собирает ошибки конфигурации с ошибками подключения connection
+func (s *configSnapshot) problemsWith(connection []string) []string {
+	problems := append([]string{}, s.Problems...)
+	problems = append(problems, configProblems(s.Config)...)
+	problems = append(problems, pathProblems(s.Config)...)
+	problems = append(problems, connection...)
+	return problems
+}
+
+// configProblems возвращает список ошибок конфигурации
+func configProblems(c *Config) []string {
+	var problems []string
+
+	if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, "BASE_URL должен быть http(s) адресом")
+	}
+
+	if c.AuthLogin == "" {
+		problems = append(problems, "AUTH_LOGIN не указан")
+	}
+	if len(c.AuthToken) != 64 {
+		problems = append(problems, fmt.Sprintf("длина AUTH_TOKEN %d, ожидается 64 символа", len(c.AuthToken)))
+	}
+
+	if c.UploadTime != "" {
+		if _, err := time.Parse("15:04", c.UploadTime); err != nil {
+			problems = append(problems, "UPLOAD_TIME должен быть в формате ЧЧ:ММ")
+		}
+		if c.UploadDay < 0 || c.UploadDay > 6 {
+			problems = append(problems, "UPLOAD_DAY должен быть от 0 (воскресенье) до 6")
+		}
+	}
+
+	problems = append(problems, valueProblems(c)...)
+	problems = append(problems, notifyRouteProblems(c)...)
+
+	return problems
+}
+
+// pathProblems проверяет, что каталоги для записи существуют и доступны
+func pathProblems(c *Config) []string {
+	var problems []string
+
+	dirs := map[string]string{"AUDIT_LOG_PATH": filepath.Dir(c.AuditLogPath)}
+	if c.LogFile != "" {
+		dirs["LOG_FILE"] = filepath.Dir(c.LogFile)
+	}
+	if c.TLSCertFile != "" {
+		dirs["TLS_CERT_FILE"] = filepath.Dir(c.TLSCertFile)
+	}
+
+	keys := make([]string, 0, len(dirs))
+	for key := range dirs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := checkWritableDir(dirs[key]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: каталог %s недоступен для записи: %v", key, dirs[key], err))
+		}
+	}
+
+	if c.AssetsDir != "" {
+		if info, err := os.Stat(c.AssetsDir); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("ASSETS_DIR: каталог %s не найден", c.AssetsDir))
+		}
+	}
+
+	if c.TLSClientCAFile != "" {
+		if _, err := os.Stat(c.TLSClientCAFile); err != nil {
+			problems = append(problems, fmt.Sprintf("TLS_CLIENT_CA_FILE: %v", err))
+		}
+	}
+
+	return problems
+}
+
+// checkWritableDir проверяет запись в каталог созданием временного файла
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("не каталог")
+	}
+
+	file, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// valueProblems проверяет значения параметров, не связанные с доступом к PIRELLI
+func valueProblems(c *Config) []string {
+	var problems []string
+
+	for key, port := range map[string]string{"SERVER_PORT": c.ServerPort, "HTTP_REDIRECT_PORT": c.HTTPRedirectPort, "SMTP_PORT": c.SMTPPort} {
+		if port == "" && key != "SERVER_PORT" {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			problems = append(problems, fmt.Sprintf("%s должен быть номером порта от 1 до 65535", key))
+		}
+	}
+
+	if c.AdminPassword == "" {
+		problems = append(problems, "ADMIN_PASSWORD не указан")
+	}
+	if c.TLSCertFile != "" && c.TLSKeyFile == "" {
+		problems = append(problems, "TLS_KEY_FILE не указан (обязателен вместе с TLS_CERT_FILE)")
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, "LOG_LEVEL должен быть debug, info, warn или error")
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		problems = append(problems, "LOG_FORMAT должен быть text или json")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "SHUTDOWN_TIMEOUT должен быть больше нуля")
+	}
+	if c.MaxUploadSizeMB < 1 {
+		problems = append(problems, "MAX_UPLOAD_SIZE_MB должен быть не меньше 1")
+	}
+	if c.MaxCSVSizeMB < 1 {
+		problems = append(problems, "MAX_CSV_SIZE_MB должен быть не меньше 1")
+	}
+	if c.JobWorkers < 1 {
+		problems = append(problems, "JOB_WORKERS должен быть не меньше 1")
+	}
+	if c.JobQueueSize < 1 {
+		problems = append(problems, "JOB_QUEUE_SIZE должен быть не меньше 1")
+	}
+	problems = append(problems, pirelliCodeProblems(c)...)
+	problems = append(problems, pirelliTransportProblems(c)...)
+	if c.ReconcileWindow <= 0 {
+		problems = append(problems, "RECONCILE_WINDOW должен быть больше нуля")
+	}
+
+	if c.WebhookURL != "" {
+		if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "WEBHOOK_URL должен быть http(s) адресом")
+		}
+	}
+	if c.SMTPHost != "" && len(c.NotifyEmailTo) == 0 && len(c.NotifyEmailToByLogin) == 0 {
+		problems = append(problems, "SMTP_HOST задан, но получатели NOTIFY_EMAIL_TO не указаны")
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// effectiveValues возвращает значения параметров со скрытыми секретами
+func (s *configSnapshot) effectiveValues() []ConfigValue {
+	creds := Credentials{Login: s.Config.AuthLogin, Token: s.Config.AuthToken}
+
+	values := make([]ConfigValue, 0, len(s.Values))
+	for _, value := range s.Values {
+		// Логин и токен могли быть заменены через ротацию токена
+		switch value.Key {
+		case "AUTH_LOGIN":
+			if value.Value != creds.Login {
+				value.Value, value.Source = creds.Login, "token_rotation"
+			}
+		case "AUTH_TOKEN":
+			if value.Value != creds.Token {
+				value.Value, value.Source = creds.Token, "token_rotation"
+			}
+		}
+		if secretConfigKeys[value.Key] && value.Value != "" {
+			value.Value = maskSecret(value.Value)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// handleConfig показывает действующую конфигурацию и источник каждого значения
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "config_view", "", "", auditDenied, "неверный пароль")
+		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+		return
+	}
+
+	snapshot := currentConfig.Load()
+	problems := snapshot.storedProblems()
+	recordAudit(r, "config_view", snapshot.File, "", auditSuccess, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ConfigFile string        `json:"config_file,omitempty"`
+		LoadedAt   time.Time     `json:"loaded_at"`
+		Valid      bool          `json:"valid"`
+		Problems   []string      `json:"problems"`
+		Values     []ConfigValue `json:"values"`
+	}{
+		ConfigFile: snapshot.File,
+		LoadedAt:   snapshot.LoadedAt,
+		Valid:      len(problems) == 0,
+		Problems:   problems,
+		Values:     snapshot.effectiveValues(),
+	})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// dashboardHistoryLimit число последних отправок на панели
+const dashboardHistoryLimit = 20
+
+// weekdayNames дни недели для расписания (0 - воскресенье)
+var weekdayNames = []string{"воскресенье", "понедельник", "вторник", "среда", "четверг", "пятница", "суббота"}
+
+// DashboardData данные страницы состояния. Без пароля администратора заполняются только
+// состояние сервера, расписание и свежесть файла; логин, путь к файлу, история отправок и
+// сверка - только при Admin
+type DashboardData struct {
+	CompanyName string
+	Admin       bool
+	Login       string
+	Status      string
+	Health      string
+	UpdatedAt   string
+	InFlight    int64
+	Queued      int
+
+	Schedule string
+	NextRuns []string
+
+	Source DashboardSource
+
+	Uploads []DashboardUpload
+	// UploadsError ошибка чтения журнала аудита
+	UploadsError string
+
+	Reconcile DashboardReconcile
+}
+
+// DashboardSource состояние файла CSV_FILE_PATH
+type DashboardSource struct {
+	Path     string
+	Exists   bool
+	Modified string
+	Age      string
+	Rows     int
+	Stale    bool
+	Error    string
+}
+
+// DashboardUpload строка истории отправок
+type DashboardUpload struct {
+	Time     string
+	Trigger  string
+	FileName string
+	Outcome  string
+	Success  bool
+	Message  string
+}
+
+// DashboardReconcile результат последней сверки с PIRELLI
+type DashboardReconcile struct {
+	Enabled bool
+	Time    string
+	Local   int
+	Remote  int
+	Error   string
+	Missing []DashboardUpload
+	Unknown []DashboardUpload
+}
+
+// nextScheduledRuns возвращает ближайшие count автоматических отправок
+func nextScheduledRuns(count int) []time.Time {
+	var runs []time.Time
+	after := time.Now()
+	for len(runs) < count {
+		next, err := nextScheduledRun(after)
+		if err != nil {
+			return runs
+		}
+		runs = append(runs, next)
+		after = next.Add(time.Minute)
+	}
+	return runs
+}
+
+// dashboardData собирает данные для страницы состояния; admin - с данными для администратора
+func dashboardData(admin bool) DashboardData {
+	c := cfg()
+	data := DashboardData{
+		CompanyName: c.CompanyName,
+		Admin:       admin,
+		Status:      serverStateName(),
+		Health:      healthSummary(runHealthChecks()),
+		UpdatedAt:   time.Now().Format("2006-01-02 15:04:05"),
+		InFlight:    uploadsInFlight.Load(),
+		Schedule:    "выключена (UPLOAD_TIME не задан)",
+	}
+
+	if c.UploadTime != "" && c.UploadDay >= 0 && c.UploadDay < len(weekdayNames) {
+		data.Schedule = fmt.Sprintf("%s, %s", weekdayNames[c.UploadDay], c.UploadTime)
+		for _, run := range nextScheduledRuns(3) {
+			data.NextRuns = append(data.NextRuns, run.Format("2006-01-02 15:04"))
+		}
+	}
+
+	data.Queued, _ = uploadPool.depth()
+
+	info, err := os.Stat(c.CSVFilePath)
+	if err == nil {
+		age := time.Since(info.ModTime())
+		data.Source.Exists = true
+		data.Source.Modified = info.ModTime().Format("2006-01-02 15:04:05")
+		data.Source.Age = formatAge(age)
+		data.Source.Stale = c.SourceMaxAge > 0 && age > c.SourceMaxAge
+	}
+	if !admin {
+		return data
+	}
+
+	data.Login = currentCredentials().Login
+	data.Source.Path = c.CSVFilePath
+	if err != nil {
+		data.Source.Error = err.Error()
+	} else {
+		data.Source.Rows = countCSVRows(c.CSVFilePath)
+	}
+
+	entries, err := readUploadHistory(c.AuditLogPath, dashboardHistoryLimit)
+	if err != nil {
+		data.UploadsError = err.Error()
+	}
+	// Новые отправки сверху; попытки с неверным паролем не показываем
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Outcome == auditDenied {
+			continue
+		}
+		data.Uploads = append(data.Uploads, DashboardUpload{
+			Time:     entry.Time.Local().Format("2006-01-02 15:04:05"),
+			Trigger:  uploadActionTrigger(entry.Action),
+			FileName: entry.Target,
+			Outcome:  entry.Outcome,
+			Success:  entry.Outcome == auditSuccess,
+			Message:  entry.Details,
+		})
+	}
+
+	data.Reconcile = dashboardReconcile()
+	return data
+}
+
+// dashboardReconcile собирает результат последней сверки для страницы состояния
+func dashboardReconcile() DashboardReconcile {
+	result := DashboardReconcile{Enabled: cfg().ReconcileInterval > 0}
+	report := lastReconcileReport()
+	if report == nil {
+		return result
+	}
+
+	result.Time = report.Time.Local().Format("2006-01-02 15:04:05")
+	result.Local = report.Local
+	result.Remote = report.Remote
+	result.Error = report.Error
+	for _, item := range report.Missing {
+		result.Missing = append(result.Missing, reconcileRow(item))
+	}
+	for _, item := range report.Unknown {
+		result.Unknown = append(result.Unknown, reconcileRow(item))
+	}
+	return result
+}
+
+// reconcileRow строка таблицы расхождений
+func reconcileRow(item ReconcileItem) DashboardUpload {
+	row := DashboardUpload{Trigger: item.Trigger, FileName: item.FileName}
+	if !item.Time.IsZero() {
+		row.Time = item.Time.Local().Format("2006-01-02 15:04:05")
+	}
+	return row
+}
+
+// formatAge записывает возраст файла в днях, часах и минутах
+func formatAge(age time.Duration) string {
+	age = age.Round(time.Minute)
+	days := int(age.Hours()) / 24
+	hours := int(age.Hours()) % 24
+	minutes := int(age.Minutes()) % 60
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%d д %d ч", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%d ч %d мин", hours, minutes)
+	default:
+		return fmt.Sprintf("%d мин", minutes)
+	}
+}
+
+// handleDashboard отображает страницу состояния. С паролем администратора в заголовке
+// X-Admin-Password страница содержит историю отправок и результат сверки
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Header.Get("X-Admin-Password") == "" {
+		renderPage(w, "dashboard.html", dashboardData(false))
+		return
+	}
+	if !checkAdminPassword(r) {
+		recordAudit(r, "dashboard_view", "", "", auditDenied, "неверный пароль")
+		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+		return
+	}
+	recordAudit(r, "dashboard_view", "", "", auditSuccess, "")
+	renderPage(w, "dashboard.html", dashboardData(true))
+}
+
+// handleUploadNow отправляет файл CSV_FILE_PATH сразу, не дожидаясь расписания
+func handleUploadNow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if rejectIfDraining(w) {
+		return
+	}
+
+	filePath := cfg().CSVFilePath
+	fileName := filepath.Base(filePath)
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "dashboard_upload", fileName, "", auditDenied, "неверный пароль")
+		sendWebResult(w, false, "Неверный пароль")
+		return
+	}
+
+	ctx := withTrigger(r.Context(), triggerWeb)
+	loggerFrom(ctx).Info("Отправка файла по кнопке \"Отправить сейчас\"", "path", filePath)
+
+	job := newSourceJob(ctx, r, "dashboard_upload", filePath)
+	job.force = forceRequested(r)
+	if err := submitJob(job); err != nil {
+		recordAudit(r, "dashboard_upload", fileName, job.uploadChecksum, auditFailure, err.Error())
+		sendWebResult(w, false, "Ошибка отправки", err.Error())
+		return
+	}
+
+	sendJobAccepted(w, job, "Отправка поставлена в очередь")
+}
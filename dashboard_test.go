@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDashboardAdminSections(t *testing.T) {
+	if pageTemplates == nil {
+		setTestConfig(t, &Config{})
+		if err := loadAssets(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	setTestConfig(t, &Config{
+		CompanyName:   "ООО Шины",
+		AuthLogin:     "5700097",
+		AdminPassword: "secret",
+		CSVFilePath:   filepath.Join(dir, "stock-source.csv"),
+		AuditLogPath:  filepath.Join(dir, "audit.log"),
+	})
+	previous := audit
+	t.Cleanup(func() { audit = previous })
+	var err error
+	if audit, err = openAuditLog(cfg().AuditLogPath); err != nil {
+		t.Fatal(err)
+	}
+	recordSchedulerAudit("scheduled_upload", "ir_5700097_20260101_090000.csv", "", auditFailure, "PIRELLI отклонил запрос: wrong columns")
+
+	secrets := []string{"5700097", "stock-source.csv", "wrong columns"}
+	for _, tt := range []struct {
+		name     string
+		password string
+		code     int
+		visible  bool
+	}{
+		{"без пароля", "", http.StatusOK, false},
+		{"неверный пароль", "wrong", http.StatusUnauthorized, false},
+		{"пароль администратора", "secret", http.StatusOK, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/dashboard", nil)
+			if tt.password != "" {
+				r.Header.Set("X-Admin-Password", tt.password)
+			}
+			w := httptest.NewRecorder()
+			handleDashboard(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("код ответа %d", w.Code)
+			}
+			for _, secret := range secrets {
+				if strings.Contains(w.Body.String(), secret) != tt.visible {
+					t.Errorf("%q на странице: %v", secret, !tt.visible)
+				}
+			}
+		})
+	}
+
+	// Просмотр с паролем и неудачная попытка попадают в журнал аудита
+	content, err := os.ReadFile(cfg().AuditLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outcomes []string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Action == "dashboard_view" {
+			outcomes = append(outcomes, entry.Outcome)
+		}
+	}
+	if strings.Join(outcomes, ",") != auditDenied+","+auditSuccess {
+		t.Errorf("записи dashboard_view: %v", outcomes)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// accountLockRetry как часто проверять, не освободил ли другой процесс блокировку логина
+const accountLockRetry = 200 * time.Millisecond
+
+var (
+	// accountLocks по одной отправке в PIRELLI на логин: планировщик, веб-форма и API
+	// не отправляют отчеты одного аккаунта одновременно. Между процессами (сервер и
+	// report-server upload) то же обеспечивает файл блокировки accountLockPath
+	accountLocksMu sync.Mutex
+	accountLocks   = map[string]chan struct{}{}
+)
+
+// accountLockPath файл блокировки отправок логина рядом с журналом аудита, общий для
+// сервера и командной строки
+func accountLockPath(login string) string {
+	return cfg().AuditLogPath + ".upload-" + url.PathEscape(login) + ".lock"
+}
+
+// lockAccount ждет, пока закончится другая отправка от логина login в этом или другом
+// процессе, или отмены ctx. waiting вызывается один раз, если приходится ждать.
+// Возвращает функцию, снимающую блокировку
+func lockAccount(ctx context.Context, login string, waiting func()) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	waited := false
+	wait := func() {
+		if !waited && waiting != nil {
+			waiting()
+		}
+		waited = true
+	}
+
+	accountLocksMu.Lock()
+	lock, ok := accountLocks[login]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		accountLocks[login] = lock
+	}
+	accountLocksMu.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+	default:
+		wait()
+		select {
+		case lock <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() { <-lock }
+
+	file, err := os.OpenFile(accountLockPath(login), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("не удалось открыть блокировку отправки: %v", err)
+	}
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			release()
+			return nil, fmt.Errorf("не удалось взять блокировку отправки: %v", err)
+		}
+		if locked {
+			break
+		}
+		wait()
+		select {
+		case <-time.After(accountLockRetry):
+		case <-ctx.Done():
+			file.Close()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		unlockFile(file)
+		file.Close()
+		release()
+	}, nil
+}
+
+// duplicateUpload ошибка повторной отправки файла, уже принятого PIRELLI
+type duplicateUpload struct {
+	entry AuditEntry
+}
+
+func (d *duplicateUpload) Error() string {
+	return fmt.Sprintf("такой же файл уже отправлен %s (%s); для повторной отправки укажите force",
+		d.entry.Time.Local().Format("2006-01-02 15:04:05"), d.entry.Target)
+}
+
+// findDuplicateUpload ищет в журнале аудита успешную отправку файла с той же контрольной
+// суммой от логина login за последние DEDUPE_WINDOW. Журнал общий для сервера и командной
+// строки; записи без логина, как и при сверке, относятся к проверяемому логину
+func findDuplicateUpload(login, checksum string) error {
+	window := cfg().DedupeWindow
+	if window <= 0 || checksum == "" {
+		return nil
+	}
+
+	entries, err := readUploadHistory(cfg().AuditLogPath, 0)
+	if err != nil {
+		// Без журнала не можем проверить повтор; отправку не блокируем
+		return nil
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if time.Since(entry.Time) > window {
+			break
+		}
+		if entry.Login != "" && entry.Login != login {
+			continue
+		}
+		if entry.Outcome == auditSuccess && entry.Checksum == checksum {
+			return &duplicateUpload{entry: entry}
+		}
+	}
+	return nil
+}
+
+// forceRequested проверяет поле формы force: отправить файл, даже если он уже отправлялся
+func forceRequested(r *http.Request) bool {
+	force, _ := strconv.ParseBool(r.FormValue("force"))
+	return force
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestLockAccountAcrossProcesses проверяет, что отправка ждет блокировку, взятую другим
+// процессом: отдельный дескриптор файла блокировки ведет себя как report-server upload
+func TestLockAccountAcrossProcesses(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "freebsd" {
+		t.Skip("блокировка файлов не поддерживается")
+	}
+	setTestConfig(t, &Config{AuditLogPath: filepath.Join(t.TempDir(), "audit.log")})
+
+	other, err := os.OpenFile(accountLockPath("5700097"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := lockFile(other); err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 3*accountLockRetry)
+	defer cancel()
+	if _, err := lockAccount(ctx, "5700097", func() { waiting++ }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("блокировка другого процесса не соблюдена: %v", err)
+	}
+	if waiting != 1 {
+		t.Errorf("waiting вызван %d раз", waiting)
+	}
+
+	// Другой логин не ждет
+	unlock, err := lockAccount(context.Background(), "5700098", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	// После освобождения блокировка берется, и повторно в процессе ждет ее снятия
+	unlockFile(other)
+	unlock, err = lockAccount(context.Background(), "5700097", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(released)
+		unlock()
+	}()
+	unlockAgain, err := lockAccount(context.Background(), "5700097", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Error("вторая отправка в процессе не дождалась первой")
+	}
+	unlockAgain()
+}
+
+func TestFindDuplicateUploadByLogin(t *testing.T) {
+	setTestConfig(t, &Config{AuditLogPath: filepath.Join(t.TempDir(), "audit.log"), DedupeWindow: time.Hour})
+	previous := audit
+	t.Cleanup(func() { audit = previous })
+	var err error
+	if audit, err = openAuditLog(cfg().AuditLogPath); err != nil {
+		t.Fatal(err)
+	}
+
+	recordLoginAudit("scheduler", "5700097", "scheduled_upload", "ir_5700097_1.csv", "sum-1", auditSuccess, "")
+	// Запись без логина сделана до того, как логин стал записываться в журнал
+	recordActorAudit("scheduler", "scheduled_upload", "ir_5700097_2.csv", "sum-2", auditSuccess, "")
+
+	for _, tt := range []struct {
+		login, checksum string
+		duplicate       bool
+	}{
+		{"5700097", "sum-1", true},
+		// Тот же файл другому аккаунту PIRELLI еще не отправлялся
+		{"5700098", "sum-1", false},
+		{"5700098", "sum-2", true},
+		{"5700097", "sum-3", false},
+	} {
+		if err := findDuplicateUpload(tt.login, tt.checksum); (err != nil) != tt.duplicate {
+			t.Errorf("%s %s: %v", tt.login, tt.checksum, err)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+// diskFreeBytes не поддерживается на этой платформе
+func diskFreeBytes(dir string) (uint64, error) {
+	return 0, errors.New("проверка свободного места не поддерживается")
+}
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "os"
+
+// lockFile на этой платформе не поддерживается: процессы не блокируют друг друга
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile снимает блокировку lockFile
+func unlockFile(file *os.File) error {
+	return nil
+}
+
+// tryLockFile всегда успешна: см. lockFile
+func tryLockFile(file *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile берет исключительную блокировку файла, общую для всех процессов
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+// unlockFile снимает блокировку lockFile
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+// tryLockFile берет блокировку lockFile, не дожидаясь ее освобождения; false - файл занят
+func tryLockFile(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -1,41 +1,34 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
-	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
-	"strings"
+	"strconv"
 	"time"
+
+	"sending-pirelli-stock/pirelli"
 )
 
 // handleWebForm отображает веб-форму для загрузки файлов
 func handleWebForm(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		// Читаем HTML шаблон из файла
-		htmlContent, err := os.ReadFile("templates/form.html")
-		if err != nil {
-			// Если файл не найден, используем встроенный шаблон
-			htmlContent = []byte(embeddedFormTemplate())
-		}
-
 		tmplData := struct {
-			CompanyName string
+			CompanyName     string
+			MaxUploadSizeMB int
 		}{
-			CompanyName: config.CompanyName,
+			CompanyName:     cfg().CompanyName,
+			MaxUploadSizeMB: cfg().MaxUploadSizeMB,
 		}
 
-		t, err := template.New("webform").Parse(string(htmlContent))
-		if err != nil {
-			http.Error(w, "Ошибка шаблона: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		t.Execute(w, tmplData)
+		renderPage(w, "form.html", tmplData)
 	}
 }
 
@@ -46,98 +39,206 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status := "running"
-	if !serverRunning {
-		status = "stopped"
-	}
+	status := serverStateName()
 
 	nextUpload := ""
-	if config.UploadTime != "" {
+	if cfg().UploadTime != "" {
 		nextUpload = calculateNextUploadTime()
 	}
 
+	checks := publicHealthChecks(r, runHealthChecks())
+
 	response := ServerStatus{
 		Status:     status,
 		Timestamp:  time.Now(),
-		Company:    config.CompanyName,
-		Login:      config.AuthLogin,
+		Company:    cfg().CompanyName,
+		Login:      currentCredentials().Login,
 		NextUpload: nextUpload,
+		Health:     healthSummary(checks),
+		Checks:     checks,
+		LastUpload: lastUploadRecord(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleUpload обрабатывает загрузку файлов через API (только POST)
+// handleUpload обрабатывает загрузку файлов через API (только POST).
+// Отправка выполняется в пуле заданий, ответ приходит после ответа PIRELLI
 func handleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Проверяем пароль из заголовка или формы
-	password := r.Header.Get("X-Admin-Password")
-	if password == "" {
-		password = r.FormValue("password")
+	if rejectIfDraining(w) {
+		return
 	}
 
-	if password != config.AdminPassword {
-		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+	job := acceptAPIUpload(w, r)
+	if job == nil {
 		return
 	}
 
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		// Клиент не дождался ответа; задание доводим до конца, его состояние есть в /api/jobs/{id}
+		return
+	}
+
+	rejected, response, err := job.outcome()
+	switch {
+	case job.isDuplicate():
+		http.Error(w, job.status().Result.Message, http.StatusConflict)
+	case rejected != "" && job.status().Phase == phaseDecoding:
+		// Архив не распакован
+		http.Error(w, job.status().Result.Message, http.StatusBadRequest)
+	case rejected != "":
+		http.Error(w, "Файл содержит потенциально опасное содержимое: "+rejected, http.StatusBadRequest)
+	case pirelli.ErrorClass(err) == pirelli.ClassRateLimited:
+		// Клиенту передаем, когда PIRELLI готов принять следующий запрос
+		var perr *pirelli.Error
+		if errors.As(err, &perr) && perr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(perr.RetryAfter.Seconds())))
+		}
+		http.Error(w, "Ошибка отправки в PIRELLI: "+err.Error(), http.StatusServiceUnavailable)
+	case response != nil:
+		// Ответ PIRELLI в JSON, в том числе отказ, возвращаем клиенту как есть
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	case err != nil:
+		http.Error(w, "Ошибка отправки в PIRELLI: "+err.Error(), http.StatusInternalServerError)
+	default:
+		http.Error(w, job.status().Result.Message, http.StatusInternalServerError)
+	}
+}
+
+// acceptAPIUpload проверяет доступ и файл запроса к API и ставит задание отправки в очередь.
+// Возвращает nil, если ответ с ошибкой уже отправлен
+func acceptAPIUpload(w http.ResponseWriter, r *http.Request) *uploadJob {
+	ctx := withTrigger(r.Context(), triggerAPI)
+
+	if err := parseUploadForm(w, r); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil
+	}
+
+	// Проверяем клиентский сертификат или пароль из заголовка или формы
+	if !checkUploadAuth(r) {
+		recordAudit(r, "api_upload", "", "", auditDenied, "неверный пароль или нет клиентского сертификата")
+		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+		return nil
+	}
+
 	// Получаем файл из формы
 	file, header, err := r.FormFile("file")
 	if err != nil {
+		recordAudit(r, "api_upload", "", "", auditRejected, "ошибка чтения файла: "+err.Error())
 		http.Error(w, "Ошибка чтения файла: "+err.Error(), http.StatusBadRequest)
-		return
+		return nil
 	}
 	defer file.Close()
 
-	// Проверяем расширение файла
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".csv") {
-		http.Error(w, "Можно загружать только CSV файлы", http.StatusBadRequest)
-		return
+	// Сохраняем файл, вычисляя контрольную сумму при копировании; содержимое проверит задание
+	tempPath, checksum, err := saveUploadedFile(file, "upload-*"+uploadFormat(header.Filename))
+	if err != nil {
+		loggerFrom(ctx).Error("Ошибка сохранения файла", "error", err)
+		http.Error(w, "Ошибка сохранения файла", http.StatusInternalServerError)
+		return nil
 	}
 
-	// Сбрасываем позицию чтения файла на начало
-	file.Seek(0, 0)
+	// Повтор запроса с тем же Idempotency-Key получает результат первого, файл не отправляется снова
+	key := r.Header.Get("Idempotency-Key")
+	if key != "" {
+		if job := jobs.byKey(key); job != nil {
+			os.Remove(tempPath)
+			return replayJob(w, r, job, checksum)
+		}
+	}
 
-	// Проверяем содержимое файла на безопасность
-	if err := validateCSVFile(file); err != nil {
-		http.Error(w, "Файл содержит потенциально опасное содержимое: "+err.Error(), http.StatusBadRequest)
-		return
+	// Проверяем формат файла
+	if uploadFormat(header.Filename) == "" {
+		os.Remove(tempPath)
+		observeValidationFailure("extension")
+		recordAudit(r, "api_upload", header.Filename, checksum, auditRejected, "файл не CSV")
+		notifyValidationRejected(ctx, header.Filename, "файл не CSV")
+		http.Error(w, unsupportedFormatMessage, http.StatusBadRequest)
+		return nil
 	}
 
-	// Снова сбрасываем позицию чтения для сохранения
-	file.Seek(0, 0)
+	job := newFileJob(ctx, r, "api_upload", header, tempPath, checksum)
+	job.force = forceRequested(r)
+	if key != "" {
+		if existing := jobs.bindKey(key, job); existing != job {
+			// Одновременный запрос с тем же ключом успел раньше
+			job.finish(phaseReceived, false, "Повтор запроса с тем же Idempotency-Key", "")
+			return replayJob(w, r, existing, checksum)
+		}
+	}
+	if err := submitJob(job); err != nil {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil
+	}
+	return job
+}
 
-	// Сохраняем файл временно
-	tempFile, err := os.CreateTemp("", "upload-*.csv")
-	if err != nil {
-		http.Error(w, "Ошибка создания временного файла", http.StatusInternalServerError)
-		return
+// replayJob возвращает задание для повторного запроса с тем же Idempotency-Key.
+// Ключ, использованный для другого файла, - ошибка клиента
+func replayJob(w http.ResponseWriter, r *http.Request, job *uploadJob, checksum string) *uploadJob {
+	if job.uploadChecksum != checksum {
+		http.Error(w, "Idempotency-Key уже использован для другого файла", http.StatusUnprocessableEntity)
+		return nil
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
+	loggerFrom(r.Context()).Info("Повтор запроса с тем же Idempotency-Key", "job_id", job.ID)
+	w.Header().Set("Idempotent-Replayed", "true")
+	return job
+}
 
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		http.Error(w, "Ошибка сохранения файла", http.StatusInternalServerError)
-		return
+// uploadFormMemory сколько формы держать в памяти; остальное mime/multipart пишет во временные файлы
+const uploadFormMemory = 1 << 20
+
+// parseUploadForm разбирает форму загрузки, ограничивая тело запроса размером MAX_UPLOAD_SIZE_MB
+func parseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes()+uploadFormMemory)
+
+	var tooLarge *http.MaxBytesError
+	if err := r.ParseMultipartForm(uploadFormMemory); errors.As(err, &tooLarge) {
+		observeValidationFailure("size")
+		return fmt.Errorf("Файл слишком большой (максимум %dMB)", cfg().MaxUploadSizeMB)
 	}
+	return nil
+}
 
-	// Отправляем файл в PIRELLI
-	filename := generatePirelliFilename()
-	response, err := uploadFileToPirelli(tempFile.Name(), filename)
+// saveUploadedFile сохраняет загруженный файл во временный, который удалит задание,
+// и возвращает его путь и контрольную сумму
+func saveUploadedFile(file io.Reader, pattern string) (string, string, error) {
+	tempFile, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", "", err
+	}
 
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tempFile, hash), file)
+	tempFile.Close()
 	if err != nil {
-		http.Error(w, "Ошибка отправки в PIRELLI: "+err.Error(), http.StatusInternalServerError)
-		return
+		os.Remove(tempFile.Name())
+		return "", "", err
 	}
+	return tempFile.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// newFileJob создает задание отправки загруженного файла, сохраненного в tempPath
+func newFileJob(ctx context.Context, r *http.Request, action string, header *multipart.FileHeader, tempPath, checksum string) *uploadJob {
+	job := newUploadJob(ctx, r, action, header.Filename)
+	job.filePath = tempPath
+	job.checksum = checksum
+	job.uploadChecksum = checksum
+	job.cleanup = func() { os.Remove(tempPath) }
+	job.report(JobEvent{Phase: phaseReceived, Message: fmt.Sprintf("Файл %s получен (%d байт)", header.Filename, header.Size)})
+	loggerFrom(ctx).Info("Создано задание загрузки", "job_id", job.ID, "original_name", header.Filename)
+	return job
 }
 
 // handleWebUpload обрабатывает загрузку файлов через веб-форму
@@ -147,15 +248,24 @@ func handleWebUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Println("Начало обработки загрузки файла через веб-форму")
+	if rejectIfDraining(w) {
+		return
+	}
 
-	// Ограничиваем размер файла (10MB)
-	r.ParseMultipartForm(10 << 20)
+	ctx := withTrigger(r.Context(), triggerWeb)
+	logger := loggerFrom(ctx)
+	logger.Info("Начало обработки загрузки файла через веб-форму")
+
+	if err := parseUploadForm(w, r); err != nil {
+		logger.Warn("Файл слишком большой", "error", err)
+		sendWebResult(w, false, err.Error())
+		return
+	}
 
 	// Проверяем пароль
-	password := r.FormValue("password")
-	if password != config.AdminPassword {
-		log.Println("Неверный пароль")
+	if !checkAdminPassword(r) {
+		logger.Warn("Неверный пароль", "ip", clientIP(r))
+		recordAudit(r, "web_upload", "", "", auditDenied, "неверный пароль")
 		sendWebResult(w, false, "Неверный пароль")
 		return
 	}
@@ -163,64 +273,141 @@ func handleWebUpload(w http.ResponseWriter, r *http.Request) {
 	// Получаем файл из формы
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		log.Printf("Ошибка чтения файла: %v", err)
+		logger.Error("Ошибка чтения файла", "error", err)
+		recordAudit(r, "web_upload", "", "", auditRejected, "ошибка чтения файла: "+err.Error())
 		sendWebResult(w, false, "Ошибка чтения файла: "+err.Error())
 		return
 	}
 	defer file.Close()
 
-	log.Printf("Получен файл: %s, размер: %d", header.Filename, header.Size)
+	logger.Info("Получен файл", "original_name", header.Filename, "size", header.Size)
 
-	// Проверяем расширение файла
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".csv") {
-		log.Println("Неверное расширение файла")
-		sendWebResult(w, false, "Можно загружать только CSV файлы")
+	// Сохраняем файл: обработка продолжится после ответа на запрос
+	tempPath, checksum, err := saveUploadedFile(file, "web-upload-*"+uploadFormat(header.Filename))
+	if err != nil {
+		logger.Error("Ошибка сохранения файла", "error", err)
+		sendWebResult(w, false, "Ошибка сохранения файла")
 		return
 	}
 
-	// Сбрасываем позицию чтения файла на начало
-	file.Seek(0, 0)
+	// Проверяем формат файла
+	if uploadFormat(header.Filename) == "" {
+		os.Remove(tempPath)
+		observeValidationFailure("extension")
+		logger.Warn("Неподдерживаемый формат файла", "original_name", header.Filename)
+		recordAudit(r, "web_upload", header.Filename, checksum, auditRejected, "файл не CSV")
+		notifyValidationRejected(ctx, header.Filename, "файл не CSV")
+		sendWebResult(w, false, unsupportedFormatMessage)
+		return
+	}
 
-	// Проверяем содержимое файла на безопасность
-	if err := validateCSVFile(file); err != nil {
-		log.Printf("Файл не прошел проверку безопасности: %v", err)
-		sendWebResult(w, false, "Файл не прошел проверку безопасности: "+err.Error())
+	job := newFileJob(ctx, r, "web_upload", header, tempPath, checksum)
+	job.force = forceRequested(r)
+	if err := submitJob(job); err != nil {
+		sendWebResult(w, false, err.Error())
 		return
 	}
 
-	// Снова сбрасываем позицию чтения для сохранения
-	file.Seek(0, 0)
+	sendJobAccepted(w, job, "Файл принят, идет обработка")
+}
 
-	// Создаем временный файл для отправки
-	tempFile, err := os.CreateTemp("", "web-upload-*.csv")
-	if err != nil {
-		log.Printf("Ошибка создания временного файла: %v", err)
-		sendWebResult(w, false, "Ошибка создания временного файла")
-		return
+// runUploadJob проверяет и отправляет файл задания, сообщая этапы подписчикам
+func runUploadJob(ctx context.Context, job *uploadJob) {
+	logger := loggerFrom(ctx).With("job_id", job.ID)
+
+	// Сжатый файл распаковываем; дальше проверяется и отправляется CSV. Расширение
+	// загруженных файлов проверено при приеме, а CSV_FILE_PATH с другим расширением
+	// (stock.txt, export) отправляется как CSV
+	if format := uploadFormat(job.FileName); format == formatGzip || format == formatZip {
+		csvPath, cleanup, err := prepareCSV(job.filePath, job.FileName)
+		if err != nil {
+			observeValidationFailure("archive")
+			logger.Warn("Файл не распакован", "error", err)
+			job.reject(err.Error())
+			job.audit(job.Action, job.FileName, job.checksum, auditRejected, "распаковка: "+err.Error())
+			notifyValidationRejected(ctx, job.FileName, err.Error())
+			job.finish(phaseDecoding, false, "Файл не распакован: "+err.Error(), "")
+			return
+		}
+		defer cleanup()
+		job.filePath = csvPath
+		job.checksum = fileSHA256(csvPath)
+		job.report(JobEvent{Phase: phaseDecoding, Message: "Распакован CSV из " + format})
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	_, err = io.Copy(tempFile, file)
+	rows := countCSVRows(job.filePath)
+	job.report(JobEvent{Phase: phaseDecoding, Message: fmt.Sprintf("Прочитано строк данных: %d", rows), Rows: rows})
+
+	// Проверяем содержимое файла на безопасность
+	job.report(JobEvent{Phase: phaseValidating, Message: "Проверка файла"})
+	if err := validateCSVFileFromPath(ctx, job.filePath); err != nil {
+		logger.Warn("Файл не прошел проверку безопасности", "error", err)
+		job.reject(err.Error())
+		job.audit(job.Action, job.FileName, job.checksum, auditRejected, "проверка файла: "+err.Error())
+		notifyValidationRejected(ctx, job.FileName, err.Error())
+		job.finish(phaseValidating, false, "Файл не прошел проверку безопасности: "+err.Error(), "")
+		return
+	}
+	// Одновременно от одного логина отправляется один файл; проверка на повтор - под той же
+	// блокировкой, чтобы две одинаковые отправки не прошли обе
+	// Отправляем от того логина, который заблокирован, даже если токен сменят во время ожидания
+	creds := currentCredentials()
+	unlock, err := lockAccount(ctx, creds.Login, func() {
+		job.report(JobEvent{Phase: phaseValidating, Message: "Ожидание завершения другой отправки " + creds.Login})
+	})
 	if err != nil {
-		log.Printf("Ошибка сохранения файла: %v", err)
-		sendWebResult(w, false, "Ошибка сохранения файла")
+		message := "Задание отменено"
+		if ctx.Err() == nil {
+			logger.Error("Блокировка отправки не получена", "error", err)
+			message = err.Error()
+		}
+		job.finish(phaseValidating, false, message, "")
 		return
 	}
+	defer unlock()
+	job.login = creds.Login
+
+	if !job.force {
+		if err := findDuplicateUpload(creds.Login, job.checksum); err != nil {
+			logger.Warn("Файл уже отправлялся, повторная отправка пропущена", "error", err)
+			job.audit(job.Action, job.FileName, job.checksum, auditRejected, "повторная отправка: "+err.Error())
+			job.finishDuplicate(err)
+			return
+		}
+	}
 
-	log.Printf("Временный файл создан: %s", tempFile.Name())
+	// Загруженные файлы получают имя по правилам PIRELLI, CSV_FILE_PATH уходит под своим
+	filename, origin := job.sendAs, ""
+	if filename == "" {
+		filename = generatePirelliFilename(creds.Login)
+		origin = "исходный файл " + job.FileName + ": "
+	}
+	job.report(JobEvent{Phase: phaseSending, Message: "Отправка в PIRELLI как " + filename, Rows: rows})
 
-	// Отправляем файл в PIRELLI
-	log.Println("Начало отправки файла в PIRELLI")
-	filename := generatePirelliFilename()
-	response, err := uploadFileToPirelli(tempFile.Name(), filename)
+	response, err := uploadFileToPirelli(ctx, creds, job.filePath, filename)
+	job.record(response, err)
 	if err != nil {
-		log.Printf("Ошибка отправки в PIRELLI: %v", err)
-		sendWebResult(w, false, "Ошибка отправки в PIRELLI: "+err.Error())
+		logger.Error("Ошибка отправки в PIRELLI", "error", err, "class", pirelli.ErrorClass(err))
+		job.audit(job.Action, filename, job.checksum, auditFailure, origin+err.Error())
+		message, details := "Ошибка отправки в PIRELLI: "+err.Error(), ""
+		var perr *pirelli.Error
+		if errors.As(err, &perr) {
+			details = pirelliHint(perr)
+		}
+		switch {
+		case ctx.Err() != nil:
+			message = "Задание отменено во время отправки"
+		case response != nil:
+			// PIRELLI ответил, но файл не принял
+			message = err.Error()
+		}
+		job.finish(phaseResponse, false, message, details)
 		return
 	}
 
-	log.Printf("Ответ от PIRELLI: статус=%t, код=%d, сообщение=%s", response.Status, response.Code, response.Message)
+	job.audit(job.Action, filename, job.checksum, pirelliOutcome(response), origin+response.Message)
+
+	logger.Info("Результат отправки", "status", response.Status, "code", response.Code, "message", response.Message)
 
 	// Формируем детали ответа
 	details := ""
@@ -229,5 +416,5 @@ func handleWebUpload(w http.ResponseWriter, r *http.Request) {
 		details = fmt.Sprintf("Файл загружен: %s (%s)", lastUpload.OriginalName, lastUpload.DateTime)
 	}
 
-	sendWebResult(w, response.Status, response.Message, details)
+	job.finish(phaseResponse, response.Status, response.Message, details)
 }
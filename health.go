@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sending-pirelli-stock/pirelli"
+)
+
+// Результаты проверок состояния
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// HealthCheck результат одной проверки состояния
+type HealthCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Message  string `json:"message,omitempty"`
+	// Detail подробности с путями к файлам, видны только с паролем администратора
+	Detail string `json:"detail,omitempty"`
+}
+
+// publicHealthChecks убирает подробности проверок, если запрос без пароля администратора
+func publicHealthChecks(r *http.Request, checks []HealthCheck) []HealthCheck {
+	if checkAdminPassword(r) {
+		return checks
+	}
+	for i := range checks {
+		checks[i].Detail = ""
+	}
+	return checks
+}
+
+// UploadRecord результат последней отправки в PIRELLI
+type UploadRecord struct {
+	Time     time.Time `json:"time"`
+	Trigger  string    `json:"trigger"`
+	FileName string    `json:"file_name"`
+	Success  bool      `json:"success"`
+	Message  string    `json:"message"`
+}
+
+var (
+	lastUploadMu sync.RWMutex
+	lastUpload   *UploadRecord
+
+	// uploadsInFlight число отправок в PIRELLI, выполняющихся прямо сейчас
+	uploadsInFlight atomic.Int64
+
+	pirelliCheckMu     sync.Mutex
+	pirelliCheckCached HealthCheck
+	pirelliCheckAt     time.Time
+)
+
+// rememberUpload сохраняет результат отправки для проверок состояния
+func rememberUpload(ctx context.Context, fileName string, response *pirelli.Response, err error) {
+	record := &UploadRecord{
+		Time:     time.Now(),
+		Trigger:  triggerFrom(ctx),
+		FileName: fileName,
+	}
+
+	switch {
+	case err != nil:
+		record.Message = err.Error()
+	default:
+		record.Success = response.Status
+		record.Message = response.Message
+	}
+
+	lastUploadMu.Lock()
+	lastUpload = record
+	lastUploadMu.Unlock()
+}
+
+// lastUploadRecord возвращает результат последней отправки
+func lastUploadRecord() *UploadRecord {
+	lastUploadMu.RLock()
+	defer lastUploadMu.RUnlock()
+	if lastUpload == nil {
+		return nil
+	}
+	record := *lastUpload
+	return &record
+}
+
+// restoreLastUpload восстанавливает результат последней отправки из журнала аудита после перезапуска
+func restoreLastUpload(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	var last, lastSuccess *AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || !isUploadAction(entry.Action) {
+			continue
+		}
+		// Отказы до отправки (неверный пароль, проверка файла) не считаются отправками
+		if entry.Outcome == auditDenied || entry.Outcome == auditRejected {
+			continue
+		}
+		e := entry
+		last = &e
+		if entry.Outcome == auditSuccess {
+			lastSuccess = &e
+		}
+	}
+
+	if last != nil {
+		lastUploadMu.Lock()
+		lastUpload = &UploadRecord{
+			Time:     last.Time,
+			Trigger:  uploadActionTrigger(last.Action),
+			FileName: last.Target,
+			Success:  last.Outcome == auditSuccess,
+			Message:  last.Details,
+		}
+		lastUploadMu.Unlock()
+	}
+	if lastSuccess != nil {
+		metricLastSuccess.Set(float64(lastSuccess.Time.Unix()))
+	}
+}
+
+// isUploadAction сообщает, относится ли действие аудита к отправке файла
+func isUploadAction(action string) bool {
+	return uploadActionTrigger(action) != ""
+}
+
+// uploadActionTrigger возвращает источник отправки по действию аудита
+func uploadActionTrigger(action string) string {
+	switch action {
+	case "api_upload":
+		return triggerAPI
+	case "web_upload":
+		return triggerWeb
+	case "scheduled_upload":
+		return triggerScheduler
+	case "dashboard_upload":
+		return triggerWeb
+	case "cli_upload":
+		return triggerCLI
+	}
+	return ""
+}
+
+// runHealthChecks выполняет все проверки состояния
+func runHealthChecks() []HealthCheck {
+	return []HealthCheck{
+		checkConfig(),
+		checkSourceFile(),
+		checkOutbox(),
+		checkLastUpload(),
+		checkReconcile(),
+		checkDiskSpace(),
+		checkPirelliReachable(),
+	}
+}
+
+// healthSummary возвращает общий результат: fail, если провалена критичная проверка
+func healthSummary(checks []HealthCheck) string {
+	summary := checkOK
+	for _, check := range checks {
+		if check.Status == checkFail && check.Critical {
+			return checkFail
+		}
+		if check.Status != checkOK {
+			summary = checkWarn
+		}
+	}
+	return summary
+}
+
+// checkConfig проверяет корректность конфигурации
+func checkConfig() HealthCheck {
+	check := HealthCheck{Name: "config", Status: checkOK, Critical: true}
+	snapshot := currentConfig.Load()
+	problems := configProblems(snapshot.Config)
+	// Подробности с путями к файлам - в check-config, не в публичном ответе
+	if len(snapshot.ConnectionProblems) > 0 {
+		problems = append(problems, "ошибка настроек подключения к PIRELLI (подробности: report-server check-config)")
+	}
+	if len(problems) > 0 {
+		check.Status = checkFail
+		check.Message = strings.Join(problems, "; ")
+	}
+	return check
+}
+
+// checkSourceFile проверяет наличие и свежесть файла для автоматической отправки
+func checkSourceFile() HealthCheck {
+	check := HealthCheck{Name: "source_file", Status: checkOK}
+
+	if cfg().UploadTime == "" {
+		check.Message = "автоматическая отправка выключена"
+		return check
+	}
+
+	info, err := os.Stat(cfg().CSVFilePath)
+	if err != nil {
+		check.Status = checkFail
+		check.Message = "исходный файл недоступен"
+		check.Detail = fmt.Sprintf("файл %s недоступен: %v", cfg().CSVFilePath, err)
+		return check
+	}
+
+	age := time.Since(info.ModTime()).Round(time.Minute)
+	check.Message = fmt.Sprintf("изменен %s назад, %d байт", age, info.Size())
+	check.Detail = cfg().CSVFilePath
+	if cfg().SourceMaxAge > 0 && age > cfg().SourceMaxAge {
+		check.Status = checkWarn
+		check.Message += fmt.Sprintf(" (старше %s)", cfg().SourceMaxAge)
+	}
+	return check
+}
+
+// checkOutbox показывает очередь заданий и число отправок, ожидающих ответа PIRELLI
+func checkOutbox() HealthCheck {
+	queued, capacity := uploadPool.depth()
+	check := HealthCheck{
+		Name:    "outbox",
+		Status:  checkOK,
+		Message: fmt.Sprintf("в очереди: %d из %d, отправок в процессе: %d", queued, capacity, uploadsInFlight.Load()),
+	}
+	if capacity > 0 && queued >= capacity {
+		check.Status = checkWarn
+		check.Message += " (очередь заполнена, новые задания отклоняются)"
+	}
+	return check
+}
+
+// checkLastUpload проверяет результат последней отправки
+func checkLastUpload() HealthCheck {
+	check := HealthCheck{Name: "last_upload", Status: checkOK}
+
+	record := lastUploadRecord()
+	if record == nil {
+		check.Status = checkWarn
+		check.Message = "отправок еще не было"
+		return check
+	}
+
+	check.Message = fmt.Sprintf("%s %s (%s): %s", record.Time.Local().Format("2006-01-02 15:04:05"), record.FileName, record.Trigger, record.Message)
+	if !record.Success {
+		check.Status = checkWarn
+	}
+	return check
+}
+
+// checkReconcile проверяет результат последней сверки с PIRELLI
+func checkReconcile() HealthCheck {
+	check := HealthCheck{Name: "reconcile", Status: checkOK}
+
+	report := lastReconcileReport()
+	switch {
+	case cfg().ReconcileInterval <= 0:
+		check.Message = "сверка выключена (RECONCILE_INTERVAL=0)"
+	case report == nil:
+		check.Message = "сверка еще не выполнялась"
+	case report.Error != "":
+		check.Status = checkWarn
+		check.Message = "сверка не выполнена: " + report.Error
+	case len(report.Missing) > 0 || len(report.Unknown) > 0:
+		check.Status = checkWarn
+		check.Message = fmt.Sprintf("нет в PIRELLI: %d, нет в нашей истории: %d", len(report.Missing), len(report.Unknown))
+	default:
+		check.Message = "расхождений нет, " + report.Time.Local().Format("2006-01-02 15:04:05")
+	}
+	return check
+}
+
+// checkDiskSpace проверяет свободное место в каталогах журнала аудита и временных файлов
+func checkDiskSpace() HealthCheck {
+	check := HealthCheck{Name: "disk_space", Status: checkOK, Critical: true}
+
+	minFree := uint64(cfg().DiskMinFreeMB) << 20
+	var messages, details []string
+	for _, dir := range []struct{ name, path string }{
+		{"журнал аудита", filepath.Dir(cfg().AuditLogPath)},
+		{"временные файлы", os.TempDir()},
+	} {
+		free, err := diskFreeBytes(dir.path)
+		if err != nil {
+			messages = append(messages, dir.name+": место не проверено")
+			details = append(details, fmt.Sprintf("%s: %v", dir.path, err))
+			continue
+		}
+		message := fmt.Sprintf("%s: свободно %d МБ", dir.name, free>>20)
+		if free < minFree {
+			check.Status = checkFail
+			message += " (мало места)"
+		}
+		messages = append(messages, message)
+		details = append(details, fmt.Sprintf("%s: свободно %d МБ", dir.path, free>>20))
+	}
+
+	check.Message = strings.Join(messages, "; ")
+	check.Detail = strings.Join(details, "; ")
+	return check
+}
+
+// checkPirelliReachable проверяет TCP доступность сервера PIRELLI (результат кэшируется)
+func checkPirelliReachable() HealthCheck {
+	pirelliCheckMu.Lock()
+	defer pirelliCheckMu.Unlock()
+
+	if time.Since(pirelliCheckAt) < 30*time.Second {
+		return pirelliCheckCached
+	}
+
+	check := HealthCheck{Name: "pirelli", Status: checkOK}
+
+	u, err := url.Parse(cfg().BaseURL)
+	if err != nil || u.Hostname() == "" {
+		check.Status = checkFail
+		check.Message = "некорректный BASE_URL"
+	} else {
+		port := u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+		address := net.JoinHostPort(u.Hostname(), port)
+		// За прокси напрямую PIRELLI может быть недоступен: проверяем сам прокси
+		if proxyURL := pirelliProxy(u); proxyURL != nil {
+			address = proxyURL.Host
+			if proxyURL.Port() == "" {
+				proxyPort := map[string]string{"https": "443", "socks5": "1080", "socks5h": "1080"}[proxyURL.Scheme]
+				if proxyPort == "" {
+					proxyPort = "80"
+				}
+				address = net.JoinHostPort(proxyURL.Hostname(), proxyPort)
+			}
+			check.Message = "прокси "
+		}
+		conn, err := net.DialTimeout("tcp", address, 3*time.Second)
+		if err != nil {
+			check.Status = checkFail
+			check.Message += fmt.Sprintf("%s недоступен: %v", address, err)
+		} else {
+			conn.Close()
+			check.Message += address + " доступен"
+		}
+	}
+
+	pirelliCheckCached = check
+	pirelliCheckAt = time.Now()
+	return check
+}
+
+// handleHealthz отвечает, что процесс жив (liveness)
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": checkOK})
+}
+
+// handleReadyz отвечает, готов ли сервер принимать отправки (readiness)
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := publicHealthChecks(r, runHealthChecks())
+	summary := healthSummary(checks)
+
+	state := serverStateName()
+	code := http.StatusOK
+	if summary == checkFail || state != "running" {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status string        `json:"status"`
+		Health string        `json:"health"`
+		Checks []HealthCheck `json:"checks"`
+	}{
+		Status: state,
+		Health: summary,
+		Checks: checks,
+	})
+}
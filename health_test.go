@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigConnectionProblems(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "pirelli-ca.pem")
+	t.Setenv("PIRELLI_CA_FILES", caFile)
+	settingsTestEnv(t, "AUTH_LOGIN=5700097\nAUTH_TOKEN="+strings.Repeat("a", 64)+"\nADMIN_PASSWORD=secret\n")
+
+	// Ошибка подключения найдена при загрузке и сохранена в опубликованном снимке
+	snapshot := currentConfig.Load()
+	if !strings.Contains(strings.Join(snapshot.ConnectionProblems, "; "), caFile) {
+		t.Fatalf("ConnectionProblems %v", snapshot.ConnectionProblems)
+	}
+
+	// Проверка состояния, /api/config и validate() снимок не изменяют; под -race
+	// одновременное чтение и запись ConnectionProblems - ошибка
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			checkConfig()
+		}
+	}()
+	for range 20 {
+		r := httptest.NewRequest("GET", "/api/config", nil)
+		r.Header.Set("X-Admin-Password", "secret")
+		w := httptest.NewRecorder()
+		handleConfig(w, r)
+		if !strings.Contains(w.Body.String(), "pirelli-ca.pem") {
+			t.Fatalf("/api/config без ошибки подключения: %s", w.Body.String())
+		}
+		snapshot.validate()
+	}
+	<-done
+
+	check := checkConfig()
+	if check.Status != checkFail {
+		t.Fatalf("config %s: %s", check.Status, check.Message)
+	}
+	if strings.Contains(check.Message, caFile) || !strings.Contains(check.Message, "подключения к PIRELLI") {
+		t.Errorf("сообщение проверки состояния %q", check.Message)
+	}
+}
+
+func TestHealthChecksHidePaths(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "stock-source.csv")
+	setTestConfig(t, &Config{
+		UploadTime:    "08:00",
+		CSVFilePath:   sourcePath,
+		AuditLogPath:  filepath.Join(dir, "audit.log"),
+		AdminPassword: "secret",
+	})
+
+	for _, tt := range []struct {
+		name     string
+		password string
+		visible  bool
+	}{
+		{"без пароля", "", false},
+		{"с паролем администратора", "secret", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/readyz", nil)
+			if tt.password != "" {
+				r.Header.Set("X-Admin-Password", tt.password)
+			}
+			w := httptest.NewRecorder()
+			handleReadyz(w, r)
+
+			body := w.Body.String()
+			if !strings.Contains(body, "исходный файл недоступен") {
+				t.Errorf("нет сообщения об исходном файле: %s", body)
+			}
+			if strings.Contains(body, sourcePath) != tt.visible || strings.Contains(body, dir) != tt.visible {
+				t.Errorf("пути к файлам в ответе: %s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// setTestConfig публикует конфигурацию на время теста и восстанавливает прежнюю после него
+func setTestConfig(t *testing.T, c *Config) {
+	t.Helper()
+	previous := currentConfig.Load()
+	currentConfig.Store(&configSnapshot{Config: c, LoadedAt: time.Now()})
+	t.Cleanup(func() { currentConfig.Store(previous) })
+}
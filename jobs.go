@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sending-pirelli-stock/pirelli"
+)
+
+// Этапы обработки загрузки
+const (
+	phaseReceived   = "received"
+	phaseDecoding   = "decoding"
+	phaseValidating = "validating"
+	phaseSending    = "sending"
+	phaseResponse   = "pirelli_response"
+)
+
+// Состояния задания
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// jobRetention сколько хранить завершенные задания
+const jobRetention = time.Hour
+
+var (
+	errQueueFull   = errors.New("очередь заданий заполнена, повторите позже")
+	errPoolStopped = errors.New("сервер останавливается, задания не принимаются")
+)
+
+// JobEvent событие задания для потока SSE
+type JobEvent struct {
+	Phase   string    `json:"phase"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Rows    int       `json:"rows,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	// Done последнее событие задания; Success - итог отправки
+	Done    bool   `json:"done,omitempty"`
+	Success bool   `json:"success,omitempty"`
+	Details string `json:"details,omitempty"`
+	// Duplicate файл уже отправлялся в DEDUPE_WINDOW; отправить повторно можно с force
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// JobStatus состояние задания для GET /api/jobs/{id}
+type JobStatus struct {
+	ID       string            `json:"id"`
+	State    string            `json:"state"`
+	Phase    string            `json:"phase"`
+	Trigger  string            `json:"trigger"`
+	FileName string            `json:"file_name"`
+	Created  time.Time         `json:"created"`
+	Started  *time.Time        `json:"started,omitempty"`
+	Finished *time.Time        `json:"finished,omitempty"`
+	Result   *UploadResult     `json:"result,omitempty"`
+	Response *pirelli.Response `json:"pirelli_response,omitempty"`
+	// PirelliError класс ошибки PIRELLI и сырой ответ для диагностики
+	PirelliError *pirelli.Error `json:"pirelli_error,omitempty"`
+	Events       []JobEvent     `json:"events"`
+}
+
+// uploadJob задание отправки, которое выполняет пул обработчиков
+type uploadJob struct {
+	ID       string
+	Created  time.Time
+	Action   string
+	Trigger  string
+	FileName string
+
+	// filePath и checksum файла для отправки (после распаковки - CSV); sendAs - имя файла
+	// для PIRELLI, пустое для загруженных файлов: им имя выдает generatePirelliFilename
+	filePath string
+	checksum string
+	sendAs   string
+	// uploadChecksum контрольная сумма файла в том виде, в каком он загружен (архив для .csv.gz и .zip)
+	uploadChecksum string
+	// force отправить файл, даже если такой же уже отправлялся в DEDUPE_WINDOW
+	force bool
+	// idempotencyKey заголовок Idempotency-Key запроса, создавшего задание
+	idempotencyKey string
+
+	// actor, claimedActor и ip инициатора: запрос к этому времени уже завершен
+	actor        string
+	claimedActor string
+	ip           string
+	// login логин PIRELLI, от которого отправляется файл; известен после блокировки логина
+	login string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// cleanup удаляет временные файлы задания после завершения
+	cleanup func()
+	done    chan struct{}
+
+	mu          sync.Mutex
+	state       string
+	phase       string
+	started     time.Time
+	finished    time.Time
+	events      []JobEvent
+	subscribers map[chan JobEvent]struct{}
+	result      *UploadResult
+	response    *pirelli.Response
+	// rejected причина отказа проверки файла, sendErr - ошибка отправки в PIRELLI
+	rejected  string
+	sendErr   error
+	duplicate bool
+}
+
+// jobStore задания по идентификатору и по ключу Idempotency-Key
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*uploadJob
+	keys map[string]*uploadJob
+}
+
+// jobs все задания загрузки
+var jobs = &jobStore{jobs: map[string]*uploadJob{}, keys: map[string]*uploadJob{}}
+
+// newUploadJob создает задание; r - запрос инициатора или nil для планировщика.
+// Задание не прерывается вместе с запросом, отменить его можно только через cancelJob
+func newUploadJob(ctx context.Context, r *http.Request, action, fileName string) *uploadJob {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	job := &uploadJob{
+		ID:          hex.EncodeToString(id),
+		Created:     time.Now(),
+		Action:      action,
+		Trigger:     triggerFrom(ctx),
+		FileName:    fileName,
+		actor:       "scheduler",
+		done:        make(chan struct{}),
+		state:       jobQueued,
+		subscribers: map[chan JobEvent]struct{}{},
+	}
+	if r != nil {
+		job.actor = requestActor(r)
+		job.claimedActor = claimedActor(r)
+		job.ip = clientIP(r)
+	}
+	job.ctx, job.cancel = context.WithCancel(context.WithoutCancel(ctx))
+
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	for id, old := range jobs.jobs {
+		if finished := old.finishedAt(); !finished.IsZero() && time.Since(finished) > jobRetention {
+			delete(jobs.jobs, id)
+			if jobs.keys[old.idempotencyKey] == old {
+				delete(jobs.keys, old.idempotencyKey)
+			}
+		}
+	}
+	jobs.jobs[job.ID] = job
+	return job
+}
+
+// get возвращает задание по идентификатору
+func (s *jobStore) get(id string) *uploadJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// byKey возвращает задание, созданное запросом с тем же Idempotency-Key
+func (s *jobStore) byKey(key string) *uploadJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[key]
+}
+
+// bindKey связывает задание с ключом Idempotency-Key. Если ключ уже занят
+// одновременным запросом, возвращает его задание
+func (s *jobStore) bindKey(key string, job *uploadJob) *uploadJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.keys[key]; ok {
+		return existing
+	}
+	job.idempotencyKey = key
+	s.keys[key] = job
+	return job
+}
+
+// finishedAt возвращает время завершения задания или нулевое время
+func (j *uploadJob) finishedAt() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finished
+}
+
+// begin переводит задание в состояние running; false, если оно уже отменено
+func (j *uploadJob) begin() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.finished.IsZero() {
+		return false
+	}
+	j.state = jobRunning
+	j.started = time.Now()
+	return true
+}
+
+// report добавляет событие и рассылает его подписчикам
+func (j *uploadJob) report(event JobEvent) {
+	event.Time = time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.finished.IsZero() {
+		return
+	}
+
+	j.phase = event.Phase
+	j.events = append(j.events, event)
+	for ch := range j.subscribers {
+		// Событий у задания немного, буфера хватает; медленный подписчик их не задерживает
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	if event.Done {
+		j.finished = event.Time
+		j.result = &UploadResult{Success: event.Success, Message: event.Message, Details: event.Details}
+		switch {
+		case event.Success:
+			j.state = jobSucceeded
+		case j.ctx.Err() != nil:
+			j.state = jobCanceled
+		default:
+			j.state = jobFailed
+		}
+
+		for ch := range j.subscribers {
+			close(ch)
+		}
+		j.subscribers = map[chan JobEvent]struct{}{}
+		close(j.done)
+		if j.cleanup != nil {
+			go j.cleanup()
+		}
+	}
+}
+
+// reject запоминает причину, по которой файл не прошел проверку
+func (j *uploadJob) reject(reason string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.rejected = reason
+}
+
+// record запоминает ответ PIRELLI или ошибку отправки
+func (j *uploadJob) record(response *pirelli.Response, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.response = response
+	j.sendErr = err
+}
+
+// outcome возвращает причину отказа проверки, ответ PIRELLI и ошибку отправки
+func (j *uploadJob) outcome() (string, *pirelli.Response, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.rejected, j.response, j.sendErr
+}
+
+// finishDuplicate завершает задание без отправки: такой же файл уже принят PIRELLI
+func (j *uploadJob) finishDuplicate(err error) {
+	j.mu.Lock()
+	j.duplicate = true
+	j.mu.Unlock()
+	j.report(JobEvent{Phase: phaseValidating, Message: "Файл не отправлен: " + err.Error(), Error: err.Error(), Done: true, Duplicate: true})
+}
+
+// isDuplicate сообщает, что задание не отправлено как повторное
+func (j *uploadJob) isDuplicate() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.duplicate
+}
+
+// finish завершает задание с итогом отправки
+func (j *uploadJob) finish(phase string, success bool, message, details string) {
+	event := JobEvent{Phase: phase, Message: message, Done: true, Success: success, Details: details}
+	if !success {
+		event.Error = message
+	}
+	j.report(event)
+}
+
+// cancelJob отменяет задание: ожидающее в очереди завершается сразу, выполняющееся
+// прерывает запрос к PIRELLI. Возвращает false, если задание уже завершено
+func (j *uploadJob) cancelJob() bool {
+	j.mu.Lock()
+	state, phase := j.state, j.phase
+	finished := !j.finished.IsZero()
+	j.mu.Unlock()
+	if finished {
+		return false
+	}
+
+	j.cancel()
+	if state == jobQueued {
+		j.finish(phase, false, "Задание отменено", "")
+	}
+	return true
+}
+
+// status возвращает состояние задания для API
+func (j *uploadJob) status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := JobStatus{
+		ID:       j.ID,
+		State:    j.state,
+		Phase:    j.phase,
+		Trigger:  j.Trigger,
+		FileName: j.FileName,
+		Created:  j.Created,
+		Result:   j.result,
+		Response: j.response,
+		Events:   append([]JobEvent{}, j.events...),
+	}
+	errors.As(j.sendErr, &status.PirelliError)
+	if !j.started.IsZero() {
+		started := j.started
+		status.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		status.Finished = &finished
+	}
+	return status
+}
+
+// subscribe возвращает прошедшие события и канал новых; канал закрывается после последнего события
+func (j *uploadJob) subscribe() ([]JobEvent, chan JobEvent, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	past := append([]JobEvent{}, j.events...)
+	if !j.finished.IsZero() {
+		return past, nil, func() {}
+	}
+
+	ch := make(chan JobEvent, 16)
+	j.subscribers[ch] = struct{}{}
+	return past, ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		delete(j.subscribers, ch)
+	}
+}
+
+// audit записывает действие задания в журнал аудита от имени инициатора
+func (j *uploadJob) audit(action, target, checksum, outcome, details string) {
+	writeAudit(AuditEntry{
+		Time:         time.Now(),
+		Actor:        j.actor,
+		ClaimedActor: j.claimedActor,
+		Login:        j.login,
+		IP:           j.ip,
+		Action:       action,
+		Target:       target,
+		Checksum:     checksum,
+		Outcome:      outcome,
+		Details:      details,
+	})
+}
+
+// jobPool очередь заданий и обработчики с ограниченной параллельностью
+type jobPool struct {
+	mu     sync.Mutex
+	queue  chan *uploadJob
+	closed bool
+}
+
+// uploadPool пул, в который ставят задания обработчики запросов и планировщик
+var uploadPool = &jobPool{}
+
+// start запускает workers обработчиков с очередью на size заданий
+func (p *jobPool) start(workers, size int) {
+	p.mu.Lock()
+	p.queue = make(chan *uploadJob, size)
+	p.mu.Unlock()
+
+	for i := 0; i < workers; i++ {
+		backgroundWG.Add(1)
+		go func() {
+			defer backgroundWG.Done()
+			p.work()
+		}()
+	}
+}
+
+// work выполняет задания из очереди, пока она не закрыта
+func (p *jobPool) work() {
+	for job := range p.queue {
+		if !job.begin() {
+			// Отменено, пока ждало в очереди
+			continue
+		}
+		runUploadJob(job.ctx, job)
+		if job.finishedAt().IsZero() {
+			job.finish(job.status().Phase, false, "Задание завершилось без результата", "")
+		}
+	}
+}
+
+// enqueue ставит задание в очередь, не дожидаясь места
+func (p *jobPool) enqueue(job *uploadJob) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.queue == nil {
+		return errPoolStopped
+	}
+	select {
+	case p.queue <- job:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// stop перестает принимать задания; обработчики завершают уже поставленные в очередь
+func (p *jobPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed && p.queue != nil {
+		p.closed = true
+		close(p.queue)
+	}
+}
+
+// depth возвращает число заданий в очереди и ее размер
+func (p *jobPool) depth() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue), cap(p.queue)
+}
+
+// sendJobAccepted отвечает 202 с идентификатором задания
+func sendJobAccepted(w http.ResponseWriter, job *uploadJob, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(UploadResult{
+		Success: true,
+		Message: message,
+		JobID:   job.ID,
+	})
+}
+
+// submitJob ставит задание в очередь; задание, которое не удалось поставить, завершается с ошибкой
+func submitJob(job *uploadJob) error {
+	if err := uploadPool.enqueue(job); err != nil {
+		loggerFrom(job.ctx).Warn("Задание не поставлено в очередь", "job_id", job.ID, "error", err)
+		job.finish(phaseReceived, false, err.Error(), "")
+		return err
+	}
+	return nil
+}
+
+// writeSSE отправляет событие в поток Server-Sent Events
+func writeSSE(w http.ResponseWriter, event JobEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Phase, data)
+	return err
+}
+
+// handleAPIJobEvents передает этапы задания API потоком Server-Sent Events; как и
+// GET /api/jobs/{id}, требует пароль администратора или клиентский сертификат
+func handleAPIJobEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkUploadAuth(r) {
+		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+		return
+	}
+	handleJobEvents(w, r)
+}
+
+// handleJobEvents передает этапы обработки загрузки потоком Server-Sent Events. Без проверки
+// пароля: EventSource не передает заголовки, поэтому доступ дает знание случайного ID задания
+func handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job := jobs.get(r.PathValue("id"))
+	if job == nil {
+		http.Error(w, "Задание не найдено", http.StatusNotFound)
+		return
+	}
+
+	// Поток длится дольше WRITE_TIMEOUT, если отправка в PIRELLI медленная
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	past, updates, unsubscribe := job.subscribe()
+	defer unsubscribe()
+
+	for _, event := range past {
+		if writeSSE(w, event) != nil {
+			return
+		}
+	}
+	rc.Flush()
+	if updates == nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-updates:
+			if !ok {
+				return
+			}
+			if writeSSE(w, event) != nil {
+				return
+			}
+			rc.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			rc.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleCreateJob принимает файл через API и ставит его отправку в очередь, не дожидаясь PIRELLI
+func handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if rejectIfDraining(w) {
+		return
+	}
+
+	job := acceptAPIUpload(w, r)
+	if job == nil {
+		return
+	}
+
+	sendJobAccepted(w, job, "Задание поставлено в очередь")
+}
+
+// handleJob возвращает состояние задания (GET) или отменяет его (DELETE)
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkUploadAuth(r) {
+		if r.Method == http.MethodDelete {
+			recordAudit(r, "job_cancel", r.PathValue("id"), "", auditDenied, "неверный пароль или нет клиентского сертификата")
+		}
+		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+		return
+	}
+
+	job := jobs.get(r.PathValue("id"))
+	if job == nil {
+		http.Error(w, "Задание не найдено", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if !job.cancelJob() {
+			http.Error(w, "Задание уже завершено", http.StatusConflict)
+			return
+		}
+		loggerFrom(r.Context()).Info("Задание отменено", "job_id", job.ID)
+		recordAudit(r, "job_cancel", job.ID, job.uploadChecksum, auditSuccess, job.FileName)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.status())
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIJobEventsAuth(t *testing.T) {
+	setTestConfig(t, &Config{AdminPassword: "secret"})
+
+	for _, tt := range []struct {
+		name     string
+		password string
+		code     int
+	}{
+		{"без пароля", "", http.StatusUnauthorized},
+		{"неверный пароль", "wrong", http.StatusUnauthorized},
+		// С паролем запрос доходит до поиска задания
+		{"пароль администратора", "secret", http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/jobs/0123/events", nil)
+			r.SetPathValue("id", "0123")
+			if tt.password != "" {
+				r.Header.Set("X-Admin-Password", tt.password)
+			}
+			w := httptest.NewRecorder()
+			handleAPIJobEvents(w, r)
+			if w.Code != tt.code {
+				t.Errorf("код ответа %d, ожидался %d", w.Code, tt.code)
+			}
+		})
+	}
+}
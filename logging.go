@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// contextKey ключ для значений в context.Context
+type contextKey string
+
+const loggerKey contextKey = "logger"
+
+// logLevel уровень логирования, изменяемый при перезагрузке конфигурации
+var logLevel = new(slog.LevelVar)
+
+// secretLogKeys ключи атрибутов, значения которых никогда не пишутся в лог
+var secretLogKeys = map[string]bool{
+	"token":          true,
+	"auth_token":     true,
+	"password":       true,
+	"admin_password": true,
+}
+
+// setupLogging настраивает slog по конфигурации: уровень, формат и файл с ротацией
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg().LogLevel)); err != nil {
+		return fmt.Errorf("неизвестный уровень логирования %q", cfg().LogLevel)
+	}
+
+	var out io.Writer = os.Stderr
+	if cfg().LogFile != "" {
+		file, err := newRotatingFile(cfg().LogFile, int64(cfg().LogMaxSizeMB)<<20, cfg().LogMaxBackups)
+		if err != nil {
+			return err
+		}
+		out = file
+	}
+
+	logLevel.Set(level)
+	options := &slog.HandlerOptions{
+		Level:       logLevel,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	switch cfg().LogFormat {
+	case "json":
+		handler = slog.NewJSONHandler(out, options)
+	case "text":
+		handler = slog.NewTextHandler(out, options)
+	default:
+		return fmt.Errorf("неизвестный формат логов %q (text или json)", cfg().LogFormat)
+	}
+
+	// slog.SetDefault перенаправляет в slog и стандартный пакет log
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// redactAttr скрывает значения секретных атрибутов
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	if secretLogKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, maskSecret(attr.Value.String()))
+	}
+	return attr
+}
+
+// fatal пишет ошибку в лог и завершает процесс
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// loggerFrom возвращает логгер запроса из контекста
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+// withLogger сохраняет логгер в контексте
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// newRequestID генерирует идентификатор запроса
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestID присваивает запросу идентификатор и кладет логгер с ним в контекст
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := sanitizeRequestID(r.Header.Get("X-Request-ID"))
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		logger.Debug("Запрос", "method", r.Method, "path", r.URL.Path, "ip", clientIP(r))
+
+		next.ServeHTTP(w, r.WithContext(withLogger(r.Context(), logger)))
+	})
+}
+
+// sanitizeRequestID принимает идентификатор клиента, только если он короткий и безопасный для логов
+func sanitizeRequestID(id string) string {
+	if len(id) == 0 || len(id) > 64 {
+		return ""
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return ""
+		}
+	}
+	return id
+}
+
+// rotatingFile файл лога с ротацией по размеру
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// newRotatingFile открывает файл лога для дозаписи
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open открывает текущий файл лога
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл лога: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("не удалось прочитать файл лога: %v", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write пишет в файл, предварительно выполняя ротацию при превышении размера
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize && f.size > 0 {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка ротации лога: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate сдвигает архивы (log.1 -> log.2 ...) и начинает новый файл
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	var moveErr error
+	if f.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		moveErr = os.Rename(f.path, f.path+".1")
+	} else {
+		moveErr = os.Remove(f.path)
+	}
+
+	// Файл открываем в любом случае, чтобы не потерять последующие записи
+	if err := f.open(); err != nil {
+		return err
+	}
+	return moveErr
+}
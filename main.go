@@ -1,114 +1,134 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 
-	"github.com/joho/godotenv"
-)
-
-// Config структура для конфигурации
-type Config struct {
-	BaseURL       string
-	CompanyName   string
-	AuthLogin     string
-	AuthToken     string
-	ServerPort    string
-	AdminPassword string
-	UploadTime    string
-	UploadDay     int
-	CSVFilePath   string
-}
-
-var (
-	config        Config
-	serverRunning bool = true
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	// Загружаем конфигурацию из .env файла
+	// Загружаем конфигурацию из файла, .env и переменных окружения
 	if err := loadConfig(); err != nil {
-		log.Printf("Ошибка загрузки конфигурации: %v", err)
-		log.Println("Продолжаем с настройками по умолчанию")
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
 	}
 
-	// Проверяем данные аутентификации
-	log.Printf("Проверка конфигурации:")
-	log.Printf("Company: %s", config.CompanyName)
-	log.Printf("Login: %s", config.AuthLogin)
-	log.Printf("Token: %s", config.AuthToken)
-	log.Printf("BaseURL: %s", config.BaseURL)
-
-	// Проверим длину токена (должен быть 64 символа для SHA256)
-	if len(config.AuthToken) != 64 {
-		log.Printf("ВНИМАНИЕ: Длина токена %d, ожидается 64 символа", len(config.AuthToken))
+	if err := setupLogging(); err != nil {
+		log.Fatalf("Ошибка настройки логирования: %v", err)
 	}
 
-	// Запускаем планировщик автоматической отправки
-	if config.UploadTime != "" && config.UploadDay >= 0 && config.UploadDay <= 6 {
-		go startScheduler()
+	// Шаблоны и статические файлы встроены в исполняемый файл, ASSETS_DIR может их заменить
+	if err := loadAssets(); err != nil {
+		fatal("Ошибка загрузки шаблонов", "error", err)
 	}
 
-	// Настраиваем HTTP маршруты
-	http.HandleFunc("/", handleWebForm)
-	http.HandleFunc("/api/status", handleStatus)
-	http.HandleFunc("/api/upload", handleUpload)
-	http.HandleFunc("/api/web-upload", handleWebUpload)
+	os.Exit(runCLI(os.Args[1:]))
+}
 
-	// Статические файлы
-	fs := http.FileServer(http.Dir("./static"))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
+// serve запускает HTTP сервер и планировщик
+func serve() {
+	// Открываем журнал аудита
+	var err error
+	audit, err = openAuditLog(cfg().AuditLogPath)
+	if err != nil {
+		fatal("Ошибка журнала аудита", "error", err)
+	}
+	restoreLastUpload(cfg().AuditLogPath)
+
+	// Проверяем конфигурацию до запуска, чтобы не обнаруживать ошибки в момент отправки
+	slog.Info("Проверка конфигурации",
+		"config_file", currentConfig.Load().File,
+		"company", cfg().CompanyName,
+		"login", cfg().AuthLogin,
+		"token", cfg().AuthToken,
+		"base_url", cfg().BaseURL)
+
+	if problems := currentConfig.Load().storedProblems(); len(problems) > 0 {
+		for _, problem := range problems {
+			slog.Error("Ошибка конфигурации", "problem", problem)
+		}
+		fatal("Сервер не запущен: исправьте конфигурацию (подробности: report-server check-config)")
+	}
 
-	// Запускаем сервер
-	log.Printf("Сервер %s запущен на порту %s", config.CompanyName, config.ServerPort)
-	log.Printf("Веб-форма доступна по: http://localhost:%s", config.ServerPort)
-	log.Printf("Статус доступен по: http://localhost:%s/api/status", config.ServerPort)
-	log.Printf("API загрузки: http://localhost:%s/api/upload", config.ServerPort)
+	// Останавливаемся по SIGINT/SIGTERM, дожидаясь текущих отправок
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	if config.UploadTime != "" {
-		log.Printf("Автоматическая отправка: %s в день недели %d", config.UploadTime, config.UploadDay)
-	}
+	// Запускаем обработчики заданий отправки: в их очередь ставят задания и API, и планировщик
+	uploadPool.start(cfg().JobWorkers, cfg().JobQueueSize)
 
-	if err := http.ListenAndServe(":"+config.ServerPort, nil); err != nil {
-		log.Fatalf("Ошибка запуска сервера: %v", err)
-	}
-}
+	// Запускаем планировщик автоматической отправки; он работает и при выключенной отправке,
+	// чтобы включить ее перезагрузкой конфигурации
+	backgroundWG.Add(1)
+	go func() {
+		defer backgroundWG.Done()
+		startScheduler(ctx)
+	}()
 
-// loadConfig загружает конфигурацию из .env файла
-func loadConfig() error {
-	// Пытаемся загрузить .env файл
-	_ = godotenv.Load()
-
-	// Устанавливаем значения по умолчанию
-	config = Config{
-		BaseURL:       getEnv("BASE_URL", "https://reports.pirelli.ru/local/templates/dealer/ajax/api.php"),
-		CompanyName:   getEnv("COMPANY_NAME", "SEMISOTNOV"),
-		AuthLogin:     getEnv("AUTH_LOGIN", "5700097"),
-		AuthToken:     getEnv("AUTH_TOKEN", "c9f5f90185a7eae42557cf298188614144bcfcfd6ac806aa6fefb63c3e814456"),
-		ServerPort:    getEnv("SERVER_PORT", "8080"),
-		AdminPassword: getEnv("ADMIN_PASSWORD", "admin123"),
-		UploadTime:    getEnv("UPLOAD_TIME", "09:00"),
-		UploadDay:     getEnvInt("UPLOAD_DAY", 1),
-		CSVFilePath:   getEnv("CSV_FILE_PATH", "./report.csv"),
-	}
+	// Перезагрузка конфигурации по SIGHUP и при изменении файлов
+	go reloadOnSignal(ctx)
+	go watchConfig(ctx)
 
-	return nil
-}
+	// Сверка истории отправок со списком файлов PIRELLI
+	go runReconciler(ctx)
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
+	// Настраиваем HTTP маршруты
+	http.Handle("/", instrument("web_form", handleWebForm))
+	http.Handle("/api/status", instrument("status", handleStatus))
+	http.Handle("/api/upload", instrument("upload", handleUpload))
+	http.Handle("/api/web-upload", instrument("web_upload", handleWebUpload))
+	http.Handle("/api/web-upload/{id}/events", instrument("web_upload_events", handleJobEvents))
+	http.Handle("/api/jobs", instrument("jobs_create", handleCreateJob))
+	http.Handle("/api/jobs/{id}", instrument("jobs", handleJob))
+	http.Handle("/api/jobs/{id}/events", instrument("jobs_events", handleAPIJobEvents))
+
+	// Панель состояния
+	http.Handle("/dashboard", instrument("dashboard", handleDashboard))
+	http.Handle("/api/admin/upload-now", instrument("upload_now", handleUploadNow))
+
+	// Ротация токена PIRELLI
+	http.Handle("/admin/token", instrument("token_page", handleTokenPage))
+	http.Handle("/api/admin/token", instrument("token_status", handleTokenStatus))
+	http.Handle("/api/admin/token/stage", instrument("token_stage", handleTokenStage))
+	http.Handle("/api/admin/token/verify", instrument("token_verify", handleTokenVerify))
+	http.Handle("/api/admin/token/activate", instrument("token_activate", handleTokenActivate))
+	http.Handle("/api/admin/token/rollback", instrument("token_rollback", handleTokenRollback))
+
+	// Действующая конфигурация
+	http.Handle("/api/config", instrument("config", handleConfig))
+	http.Handle("/api/admin/config/reload", instrument("config_reload", handleConfigReload))
+
+	// Настройки
+	http.Handle("/admin/settings", instrument("settings_page", handleSettingsPage))
+	http.Handle("/api/admin/settings", instrument("settings", handleSettings))
+
+	// Файлы на стороне PIRELLI
+	http.Handle("/api/pirelli/uploads", instrument("pirelli_uploads", handlePirelliUploads))
+	http.Handle("/api/pirelli/reconcile", instrument("pirelli_reconcile", handlePirelliReconcile))
+
+	// Журнал аудита
+	http.Handle("/api/audit", instrument("audit_export", handleAuditExport))
+
+	// Уведомления
+	http.Handle("/api/admin/notify/test", instrument("notify_test", handleTestNotify))
+	http.Handle("/api/admin/notify/test-email", instrument("notify_test", handleTestNotify))
+
+	// Проверки состояния
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+
+	// Метрики Prometheus
+	http.Handle("/metrics", promhttp.Handler())
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
+	// Статические файлы
+	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFiles))))
+
+	if err := runServer(ctx); err != nil {
+		fatal("Ошибка запуска сервера", "error", err)
 	}
-	return defaultValue
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"sending-pirelli-stock/pirelli"
+)
+
+const triggerKey contextKey = "trigger"
+
+// Источники отправки для метрик и логов
+const (
+	triggerAPI       = "api"
+	triggerWeb       = "web"
+	triggerScheduler = "scheduler"
+	triggerCLI       = "cli"
+)
+
+var (
+	metricUploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pirelli_uploads_total",
+		Help: "Отправки отчетов в PIRELLI по источнику и результату (success, rejected, error).",
+	}, []string{"trigger", "outcome"})
+
+	metricResponseCodes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pirelli_response_codes_total",
+		Help: "Ответы PIRELLI по HTTP статусу и коду из тела ответа.",
+	}, []string{"http_status", "code"})
+
+	metricPirelliErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pirelli_errors_total",
+		Help: "Ошибки обращения к PIRELLI по классу (auth, validation, rate_limited, server, transport).",
+	}, []string{"class"})
+
+	metricUploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pirelli_upload_duration_seconds",
+		Help:    "Длительность запроса отправки в PIRELLI.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30, 60},
+	}, []string{"trigger"})
+
+	metricUploadPayload = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pirelli_upload_payload_bytes",
+		Help:    "Размер тела запроса отправки в PIRELLI.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"trigger"})
+
+	metricValidationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "csv_validation_failures_total",
+		Help: "Файлы, не прошедшие проверку, по нарушенному правилу.",
+	}, []string{"rule"})
+
+	metricSchedulerNextRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "scheduler_next_run_timestamp_seconds",
+		Help: "Время следующей автоматической отправки (unix).",
+	})
+
+	metricLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pirelli_last_success_timestamp_seconds",
+		Help: "Время последней успешной отправки в PIRELLI (unix).",
+	})
+
+	metricReconcileDiscrepancies = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pirelli_reconcile_discrepancies",
+		Help: "Расхождения последней сверки с PIRELLI: missing - нет в PIRELLI, unknown - нет в нашей истории.",
+	}, []string{"kind"})
+
+	metricHTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP запросы по обработчику, методу и коду ответа.",
+	}, []string{"handler", "method", "code"})
+)
+
+// withTrigger сохраняет источник отправки в контексте и в логгере
+func withTrigger(ctx context.Context, trigger string) context.Context {
+	ctx = context.WithValue(ctx, triggerKey, trigger)
+	return withLogger(ctx, loggerFrom(ctx).With("trigger", trigger))
+}
+
+// triggerFrom возвращает источник отправки из контекста
+func triggerFrom(ctx context.Context) string {
+	if trigger, ok := ctx.Value(triggerKey).(string); ok {
+		return trigger
+	}
+	return "unknown"
+}
+
+// observeUpload записывает метрики одной отправки в PIRELLI
+func observeUpload(ctx context.Context, started time.Time, payloadSize int, response *pirelli.Response, err error) {
+	trigger := triggerFrom(ctx)
+
+	metricUploadDuration.WithLabelValues(trigger).Observe(time.Since(started).Seconds())
+	if payloadSize > 0 {
+		metricUploadPayload.WithLabelValues(trigger).Observe(float64(payloadSize))
+	}
+
+	switch {
+	case response != nil && !response.Status:
+		metricUploads.WithLabelValues(trigger, "rejected").Inc()
+	case err != nil:
+		metricUploads.WithLabelValues(trigger, "error").Inc()
+	case response.Status:
+		metricUploads.WithLabelValues(trigger, "success").Inc()
+		metricLastSuccess.SetToCurrentTime()
+	default:
+		metricUploads.WithLabelValues(trigger, "rejected").Inc()
+	}
+}
+
+// observeResponseCode записывает код ответа PIRELLI
+func observeResponseCode(httpStatus int, response *pirelli.Response) {
+	code := "unparsed"
+	if response != nil {
+		code = strconv.Itoa(response.Code)
+	}
+	metricResponseCodes.WithLabelValues(strconv.Itoa(httpStatus), code).Inc()
+}
+
+// observePirelliError увеличивает счетчик ошибок PIRELLI класса class
+func observePirelliError(class string) {
+	metricPirelliErrors.WithLabelValues(class).Inc()
+}
+
+// observeValidationFailure увеличивает счетчик нарушенного правила проверки
+func observeValidationFailure(rule string) {
+	metricValidationFailures.WithLabelValues(rule).Inc()
+}
+
+// instrument считает запросы к обработчику
+func instrument(name string, handler http.HandlerFunc) http.Handler {
+	return promhttp.InstrumentHandlerCounter(
+		metricHTTPRequests.MustCurryWith(prometheus.Labels{"handler": name}),
+		handler,
+	)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sending-pirelli-stock/pirelli"
+)
+
+// События для уведомлений
+const (
+	eventUploadSuccess      = "upload_success"
+	eventUploadFailure      = "upload_failure"
+	eventValidationRejected = "validation_rejected"
+	eventSchedulerMissed    = "scheduler_missed"
+	eventSourceStale        = "source_stale"
+	eventReconcileMismatch  = "reconcile_mismatch"
+	eventTest               = "test"
+)
+
+// notifyEventTypes все события, которые можно указать в NOTIFY_ROUTES
+var notifyEventTypes = []string{
+	eventUploadSuccess,
+	eventUploadFailure,
+	eventValidationRejected,
+	eventSchedulerMissed,
+	eventSourceStale,
+	eventReconcileMismatch,
+}
+
+// NotifyEvent данные события для шаблонов уведомлений
+type NotifyEvent struct {
+	Type       string
+	Time       time.Time
+	Company    string
+	Login      string
+	Trigger    string
+	FileName   string
+	Rows       int
+	Message    string
+	Error      string
+	SourcePath string
+	SourceAge  time.Duration
+	Scheduled  time.Time
+	Suppressed int
+	// Missing и Unknown расхождения сверки с PIRELLI
+	Missing []ReconcileItem
+	Unknown []ReconcileItem
+}
+
+// notifier канал доставки уведомлений
+type notifier interface {
+	Name() string
+	Enabled() bool
+	Send(event NotifyEvent) error
+}
+
+// notifiers все каналы уведомлений
+var notifiers = []notifier{
+	emailNotifier{},
+	telegramNotifier{},
+	webhookNotifier{},
+}
+
+// notifyWG отслеживает отправку уведомлений, которых ждем при остановке
+var notifyWG sync.WaitGroup
+
+// notifyLimiter ограничивает частоту уведомлений одного типа в один канал
+var notifyLimiter = &rateLimiter{last: map[string]time.Time{}, suppressed: map[string]int{}}
+
+// rateLimiter пропускает не больше одного уведомления за интервал и считает пропущенные
+type rateLimiter struct {
+	mu         sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+}
+
+// allow сообщает, можно ли отправить уведомление, и сколько похожих было пропущено до него
+func (l *rateLimiter) allow(key string, interval time.Duration) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := l.last[key]; ok && now.Sub(last) < interval {
+		l.suppressed[key]++
+		return false, 0
+	}
+
+	suppressed := l.suppressed[key]
+	l.last[key] = now
+	delete(l.suppressed, key)
+	return true, suppressed
+}
+
+// parseNotifyRoutes разбирает NOTIFY_ROUTES вида "upload_failure=email,telegram;upload_success=email"
+func parseNotifyRoutes(value string) map[string][]string {
+	routes := map[string][]string{}
+	for _, rule := range strings.Split(value, ";") {
+		event, channels, ok := strings.Cut(rule, "=")
+		event = strings.TrimSpace(event)
+		if !ok || event == "" {
+			continue
+		}
+		routes[event] = splitList(channels)
+	}
+	return routes
+}
+
+// notifyRouteProblems возвращает ошибки в NOTIFY_ROUTES
+func notifyRouteProblems(c *Config) []string {
+	var problems []string
+	for event, channels := range c.NotifyRoutes {
+		if event != "*" && !containsString(notifyEventTypes, event) {
+			problems = append(problems, fmt.Sprintf("NOTIFY_ROUTES: неизвестное событие %q", event))
+		}
+		for _, channel := range channels {
+			if findNotifier(channel) == nil {
+				problems = append(problems, fmt.Sprintf("NOTIFY_ROUTES: неизвестный канал %q", channel))
+			}
+		}
+	}
+	return problems
+}
+
+// containsString сообщает, есть ли строка в списке
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// findNotifier возвращает канал по имени
+func findNotifier(name string) notifier {
+	for _, n := range notifiers {
+		if n.Name() == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// routeNotifiers возвращает каналы для события: из NOTIFY_ROUTES или все настроенные
+func routeNotifiers(eventType string) []notifier {
+	names, ok := cfg().NotifyRoutes[eventType]
+	if !ok {
+		names, ok = cfg().NotifyRoutes["*"]
+	}
+
+	var result []notifier
+	for _, n := range notifiers {
+		if !n.Enabled() {
+			continue
+		}
+		if ok && !containsString(names, n.Name()) {
+			continue
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+// newNotifyEvent создает событие с общими полями
+func newNotifyEvent(ctx context.Context, eventType string) NotifyEvent {
+	return NotifyEvent{
+		Type:    eventType,
+		Time:    time.Now(),
+		Company: cfg().CompanyName,
+		Login:   currentCredentials().Login,
+		Trigger: triggerFrom(ctx),
+	}
+}
+
+// notify отправляет событие в каналы по маршрутам в фоне, не задерживая отправку отчета
+func notify(ctx context.Context, event NotifyEvent) {
+	logger := loggerFrom(ctx)
+
+	for _, n := range routeNotifiers(event.Type) {
+		allowed, suppressed := notifyLimiter.allow(n.Name()+"/"+event.Type, cfg().NotifyRateLimit)
+		if !allowed {
+			logger.Debug("Уведомление пропущено ограничением частоты", "channel", n.Name(), "event", event.Type)
+			continue
+		}
+
+		e := event
+		e.Suppressed = suppressed
+
+		notifyWG.Add(1)
+		go func(n notifier) {
+			defer notifyWG.Done()
+			if err := n.Send(e); err != nil {
+				logger.Error("Ошибка отправки уведомления", "channel", n.Name(), "event", e.Type, "error", err)
+				return
+			}
+			logger.Info("Уведомление отправлено", "channel", n.Name(), "event", e.Type)
+		}(n)
+	}
+}
+
+// notifyUploadResult отправляет уведомление о результате отправки в PIRELLI от логина login.
+// Логин берется из отправки: данные аутентификации могли смениться, пока она ждала очереди
+func notifyUploadResult(ctx context.Context, login, filePath, fileName string, response *pirelli.Response, err error) {
+	event := newNotifyEvent(ctx, eventUploadSuccess)
+	event.Login = login
+	event.FileName = fileName
+	event.Rows = countCSVRows(filePath)
+
+	switch {
+	case err != nil:
+		event.Type = eventUploadFailure
+		event.Error = err.Error()
+		if response != nil {
+			event.Message = response.Message
+		}
+	default:
+		event.Message = response.Message
+	}
+
+	notify(ctx, event)
+}
+
+// notifyValidationRejected отправляет уведомление о файле, не прошедшем проверку
+func notifyValidationRejected(ctx context.Context, fileName string, reason string) {
+	event := newNotifyEvent(ctx, eventValidationRejected)
+	event.FileName = fileName
+	event.Error = reason
+	notify(ctx, event)
+}
+
+// notifySchedulerMissed отправляет уведомление о пропущенной автоматической отправке
+func notifySchedulerMissed(ctx context.Context, scheduled time.Time, reason string) {
+	event := newNotifyEvent(ctx, eventSchedulerMissed)
+	event.Scheduled = scheduled
+	event.Error = reason
+	notify(ctx, event)
+}
+
+// notifySourceStale отправляет уведомление об устаревшем файле для автоматической отправки
+func notifySourceStale(ctx context.Context, filePath string, age time.Duration) {
+	event := newNotifyEvent(ctx, eventSourceStale)
+	event.SourcePath = filePath
+	event.SourceAge = age.Round(time.Minute)
+	notify(ctx, event)
+}
+
+// notifyReconcileMismatch отправляет уведомление о расхождениях истории отправок со списком PIRELLI
+func notifyReconcileMismatch(ctx context.Context, report *ReconcileReport) {
+	event := newNotifyEvent(ctx, eventReconcileMismatch)
+	event.Missing = report.Missing
+	event.Unknown = report.Unknown
+	notify(ctx, event)
+}
+
+// renderNotifyTemplate заполняет шаблон события templates/<kind>/<событие>.tmpl; первая строка - заголовок
+func renderNotifyTemplate(kind string, event NotifyEvent) (string, string, error) {
+	t, ok := notifyTemplates[kind+"/"+event.Type]
+	if !ok {
+		return "", "", fmt.Errorf("шаблон %s/%s не найден", kind, event.Type)
+	}
+
+	var out bytes.Buffer
+	if err := t.Execute(&out, event); err != nil {
+		return "", "", fmt.Errorf("ошибка заполнения шаблона %s/%s: %v", kind, event.Type, err)
+	}
+
+	title, body, _ := strings.Cut(out.String(), "\n")
+	body = strings.TrimLeft(body, "\n")
+	if event.Suppressed > 0 {
+		body += fmt.Sprintf("\n(похожих уведомлений пропущено: %d)\n", event.Suppressed)
+	}
+	return strings.TrimSpace(title), body, nil
+}
+
+// handleTestNotify отправляет тестовое уведомление в выбранный канал (по умолчанию email)
+func handleTestNotify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "notify_test", "", "", auditDenied, "неверный пароль")
+		sendWebResult(w, false, "Неверный пароль")
+		return
+	}
+
+	name := r.FormValue("channel")
+	if name == "" {
+		name = "email"
+	}
+
+	n := findNotifier(name)
+	if n == nil {
+		sendWebResult(w, false, "Неизвестный канал: "+name)
+		return
+	}
+	if !n.Enabled() {
+		sendWebResult(w, false, "Канал "+name+" не настроен")
+		return
+	}
+
+	// Тестовое уведомление отправляется сразу, без ограничения частоты
+	if err := n.Send(newNotifyEvent(r.Context(), eventTest)); err != nil {
+		loggerFrom(r.Context()).Error("Ошибка отправки тестового уведомления", "channel", name, "error", err)
+		recordAudit(r, "notify_test", name, "", auditFailure, err.Error())
+		sendWebResult(w, false, "Ошибка отправки: "+err.Error())
+		return
+	}
+
+	recordAudit(r, "notify_test", name, "", auditSuccess, "")
+	sendWebResult(w, true, "Тестовое уведомление отправлено", name)
+}
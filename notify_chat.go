@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// chatClient HTTP клиент для Telegram и вебхуков
+var chatClient = &http.Client{Timeout: 15 * time.Second}
+
+// telegramNotifier канал уведомлений через Telegram бота
+type telegramNotifier struct{}
+
+// Name возвращает имя канала для NOTIFY_ROUTES
+func (telegramNotifier) Name() string { return "telegram" }
+
+// Enabled сообщает, настроен ли Telegram бот
+func (telegramNotifier) Enabled() bool {
+	return cfg().TelegramBotToken != "" && len(cfg().TelegramChatIDs) > 0
+}
+
+// Send отправляет сообщение во все чаты TELEGRAM_CHAT_ID
+func (telegramNotifier) Send(event NotifyEvent) error {
+	title, body, err := renderNotifyTemplate("chat", event)
+	if err != nil {
+		return err
+	}
+	text := title + "\n" + body
+
+	endpoint := strings.TrimRight(cfg().TelegramAPIURL, "/") + "/bot" + cfg().TelegramBotToken + "/sendMessage"
+
+	var errs []error
+	for _, chatID := range cfg().TelegramChatIDs {
+		payload, _ := json.Marshal(map[string]any{
+			"chat_id":                  chatID,
+			"text":                     text,
+			"disable_web_page_preview": true,
+		})
+		if err := postJSON(endpoint, payload); err != nil {
+			errs = append(errs, fmt.Errorf("чат %s: %v", chatID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// webhookNotifier канал уведомлений через JSON вебхук (совместим со Slack и Mattermost)
+type webhookNotifier struct{}
+
+// Name возвращает имя канала для NOTIFY_ROUTES
+func (webhookNotifier) Name() string { return "webhook" }
+
+// Enabled сообщает, настроен ли вебхук
+func (webhookNotifier) Enabled() bool { return cfg().WebhookURL != "" }
+
+// Send отправляет событие на WEBHOOK_URL: поле text для мессенджеров и поля события
+func (webhookNotifier) Send(event NotifyEvent) error {
+	title, body, err := renderNotifyTemplate("chat", event)
+	if err != nil {
+		return err
+	}
+
+	payload, _ := json.Marshal(map[string]any{
+		"text":       title + "\n" + body,
+		"event":      event.Type,
+		"time":       event.Time,
+		"company":    event.Company,
+		"login":      event.Login,
+		"trigger":    event.Trigger,
+		"file_name":  event.FileName,
+		"rows":       event.Rows,
+		"message":    event.Message,
+		"error":      event.Error,
+		"suppressed": event.Suppressed,
+	})
+	return postJSON(cfg().WebhookURL, payload)
+}
+
+// postJSON отправляет JSON и проверяет код ответа; адрес в ошибку не попадает, так как содержит токен
+func postJSON(endpoint string, payload []byte) error {
+	resp, err := chatClient.Post(endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("ошибка запроса: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ответ %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// emailNotifier канал уведомлений по почте
+type emailNotifier struct{}
+
+// Name возвращает имя канала для NOTIFY_ROUTES
+func (emailNotifier) Name() string { return "email" }
+
+// Enabled сообщает, настроена ли отправка почты
+func (emailNotifier) Enabled() bool { return cfg().SMTPHost != "" }
+
+// Send формирует письмо по шаблону и отправляет его получателям логина
+func (emailNotifier) Send(event NotifyEvent) error {
+	recipients := emailRecipients(event.Login)
+	if len(recipients) == 0 {
+		return fmt.Errorf("получатели не настроены (NOTIFY_EMAIL_TO)")
+	}
+
+	subject, body, err := renderNotifyTemplate("email", event)
+	if err != nil {
+		return err
+	}
+
+	return sendSMTP(recipients, buildEmailMessage(recipients, subject, body))
+}
+
+// emailRecipients возвращает получателей для логина: NOTIFY_EMAIL_TO_<LOGIN> или NOTIFY_EMAIL_TO
+func emailRecipients(login string) []string {
+	if recipients, ok := cfg().NotifyEmailToByLogin[strings.ToUpper(login)]; ok && len(recipients) > 0 {
+		return recipients
+	}
+	return cfg().NotifyEmailTo
+}
+
+// buildEmailMessage собирает письмо в формате RFC 5322
+func buildEmailMessage(recipients []string, subject, body string) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg().SMTPFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		msg.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	msg.WriteString(encoded + "\r\n")
+
+	return msg.Bytes()
+}
+
+// smtpDial подключается к SMTP серверу; в тестах заменяется подключением к заглушке
+var smtpDial = func(network, address string) (net.Conn, error) {
+	return (&net.Dialer{Timeout: 15 * time.Second}).Dial(network, address)
+}
+
+// sendSMTP отправляет письмо через SMTP сервер (STARTTLS, если сервер его поддерживает)
+func sendSMTP(recipients []string, msg []byte) error {
+	c := cfg()
+	address := net.JoinHostPort(c.SMTPHost, c.SMTPPort)
+
+	conn, err := smtpDial("tcp", address)
+	if err != nil {
+		return fmt.Errorf("не удалось подключиться к %s: %v", address, err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	if c.SMTPImplicitTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: c.SMTPHost})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return fmt.Errorf("не удалось подключиться к %s: %v", address, err)
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, c.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("ошибка SMTP: %v", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !c.SMTPImplicitTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: c.SMTPHost}); err != nil {
+			return fmt.Errorf("ошибка STARTTLS: %v", err)
+		}
+	}
+
+	if c.SMTPUsername != "" {
+		auth := smtp.PlainAuth("", c.SMTPUsername, c.SMTPPassword, c.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("ошибка аутентификации SMTP: %v", err)
+		}
+	}
+
+	if err := client.Mail(c.SMTPFrom); err != nil {
+		return fmt.Errorf("ошибка MAIL FROM: %v", err)
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("ошибка RCPT TO %s: %v", recipient, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("ошибка DATA: %v", err)
+	}
+	if _, err := writer.Write(msg); err != nil {
+		return fmt.Errorf("ошибка передачи письма: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("ошибка передачи письма: %v", err)
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sending-pirelli-stock/pirelli"
+)
+
+// smtpMessage письмо, принятое заглушкой SMTP
+type smtpMessage struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+}
+
+// smtpStub SMTP сервер на локальном порту, который принимает письма без проверок
+type smtpStub struct {
+	listener net.Listener
+	messages chan smtpMessage
+	wg       sync.WaitGroup
+}
+
+// startSMTPStub запускает заглушку и направляет на нее smtpDial на время теста
+func startSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &smtpStub{listener: listener, messages: make(chan smtpMessage, 10)}
+
+	stub.wg.Add(1)
+	go func() {
+		defer stub.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			stub.wg.Add(1)
+			go func() {
+				defer stub.wg.Done()
+				stub.serve(t, conn)
+			}()
+		}
+	}()
+
+	previous := smtpDial
+	smtpDial = func(network, address string) (net.Conn, error) {
+		return net.Dial(network, listener.Addr().String())
+	}
+	t.Cleanup(func() {
+		smtpDial = previous
+		listener.Close()
+		stub.wg.Wait()
+	})
+	return stub
+}
+
+// serve ведет один SMTP диалог: без STARTTLS и аутентификации
+func (s *smtpStub) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var message smtpMessage
+	reply("220 stub")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		switch verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0]); verb {
+		case "EHLO", "HELO":
+			reply("250 stub")
+		case "MAIL":
+			message.From = strings.Trim(strings.TrimPrefix(command, "MAIL FROM:"), "<>")
+			reply("250 OK")
+		case "RCPT":
+			message.To = append(message.To, strings.Trim(strings.TrimPrefix(command, "RCPT TO:"), "<>"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			if err := message.parse(data.String()); err != nil {
+				t.Errorf("письмо не разбирается: %v", err)
+			}
+			s.messages <- message
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// parse заполняет тему и текст из письма в формате RFC 5322
+func (m *smtpMessage) parse(data string) error {
+	parsed, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if m.Subject, err = new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject")); err != nil {
+		return err
+	}
+	body, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, parsed.Body))
+	m.Body = string(body)
+	return err
+}
+
+// wait возвращает следующее принятое письмо
+func (s *smtpStub) wait(t *testing.T) smtpMessage {
+	t.Helper()
+	notifyWG.Wait()
+	select {
+	case message := <-s.messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("письмо не получено")
+		return smtpMessage{}
+	}
+}
+
+// setEmailTestConfig включает только почтовый канал
+func setEmailTestConfig(t *testing.T) {
+	t.Helper()
+	if notifyTemplates == nil {
+		setTestConfig(t, &Config{})
+		if err := loadAssets(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setTestConfig(t, &Config{
+		CompanyName:   "ООО Шины",
+		AuthLogin:     "5700097",
+		SMTPHost:      "smtp.example.com",
+		SMTPPort:      "25",
+		SMTPFrom:      "reports@example.com",
+		NotifyEmailTo: []string{"manager@example.com", "it@example.com"},
+		NotifyRoutes:  map[string][]string{},
+	})
+	notifyLimiter = &rateLimiter{last: map[string]time.Time{}, suppressed: map[string]int{}}
+}
+
+func TestEmailUploadSuccess(t *testing.T) {
+	stub := startSMTPStub(t)
+	setEmailTestConfig(t)
+
+	csvPath := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(csvPath, []byte("sku;qty\n1;5\n2;7\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withTrigger(context.Background(), triggerScheduler)
+	notifyUploadResult(ctx, "5700097", csvPath, "ir_5700097_20260101_090000.csv", &pirelli.Response{Status: true, Message: "Файл принят"}, nil)
+	message := stub.wait(t)
+
+	if message.From != "reports@example.com" {
+		t.Errorf("MAIL FROM %q", message.From)
+	}
+	if strings.Join(message.To, ",") != "manager@example.com,it@example.com" {
+		t.Errorf("получатели %v", message.To)
+	}
+	if message.Subject != "ООО Шины: отчет отправлен в PIRELLI (ir_5700097_20260101_090000.csv)" {
+		t.Errorf("тема %q", message.Subject)
+	}
+	for _, want := range []string{"Логин: 5700097", "Строк: 2", "Ответ PIRELLI: Файл принят", "Источник: " + triggerScheduler} {
+		if !strings.Contains(message.Body, want) {
+			t.Errorf("в письме нет %q:\n%s", want, message.Body)
+		}
+	}
+}
+
+func TestEmailUploadFailure(t *testing.T) {
+	stub := startSMTPStub(t)
+	setEmailTestConfig(t)
+
+	err := &pirelli.Error{Class: pirelli.ClassAuth, HTTPStatus: 401, Message: "неверный токен"}
+	notifyUploadResult(context.Background(), "5700097", "", "ir_5700097_20260101_090000.csv", nil, err)
+	message := stub.wait(t)
+
+	if message.Subject != "ООО Шины: ОШИБКА отправки отчета в PIRELLI" {
+		t.Errorf("тема %q", message.Subject)
+	}
+	if !strings.Contains(message.Body, "Ошибка: "+err.Error()) {
+		t.Errorf("в письме нет ошибки:\n%s", message.Body)
+	}
+	if strings.Contains(message.Body, "Строк:") {
+		t.Errorf("без файла число строк не выводится:\n%s", message.Body)
+	}
+}
+
+func TestEmailSourceStale(t *testing.T) {
+	stub := startSMTPStub(t)
+	setEmailTestConfig(t)
+
+	notifySourceStale(context.Background(), "/data/report.csv", 50*time.Hour+20*time.Second)
+	message := stub.wait(t)
+
+	if message.Subject != "ООО Шины: файл остатков устарел" {
+		t.Errorf("тема %q", message.Subject)
+	}
+	for _, want := range []string{"Файл: /data/report.csv", "Изменен: 50h0m0s назад"} {
+		if !strings.Contains(message.Body, want) {
+			t.Errorf("в письме нет %q:\n%s", want, message.Body)
+		}
+	}
+}
+
+func TestEmailUploadLogin(t *testing.T) {
+	stub := startSMTPStub(t)
+	setEmailTestConfig(t)
+	c := *cfg()
+	c.NotifyEmailToByLogin = map[string][]string{"SHOP1": {"shop1@example.com"}}
+	setTestConfig(t, &c)
+
+	// Текущий логин 5700097, а отправка шла от shop1 до смены данных аутентификации
+	notifyUploadResult(context.Background(), "shop1", "", "ir_shop1_20260101_090000.csv", &pirelli.Response{Status: true}, nil)
+	message := stub.wait(t)
+
+	if strings.Join(message.To, ",") != "shop1@example.com" {
+		t.Errorf("получатели %v", message.To)
+	}
+	if !strings.Contains(message.Body, "Логин: shop1") {
+		t.Errorf("в письме не логин отправки:\n%s", message.Body)
+	}
+}
+
+func TestEmailRecipientsByLogin(t *testing.T) {
+	setTestConfig(t, &Config{
+		NotifyEmailTo:        []string{"all@example.com"},
+		NotifyEmailToByLogin: map[string][]string{"SHOP1": {"shop1@example.com"}},
+	})
+
+	if got := emailRecipients("shop1"); strings.Join(got, ",") != "shop1@example.com" {
+		t.Errorf("shop1: %v", got)
+	}
+	if got := emailRecipients("shop2"); strings.Join(got, ",") != "all@example.com" {
+		t.Errorf("shop2: %v", got)
+	}
+}
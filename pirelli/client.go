@@ -0,0 +1,248 @@
+// Package pirelli клиент API PIRELLI для отправки отчетов об остатках
+package pirelli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// DefaultBoundary boundary формы как в 1С
+const DefaultBoundary = "----WebKitFormBoundary7MA4YWxkTrZu0gW"
+
+// Credentials логин и токен API PIRELLI
+type Credentials struct {
+	Login string
+	Token string
+}
+
+// Field дополнительное поле формы запроса
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Client клиент API PIRELLI. Поля можно менять после New до первого запроса
+type Client struct {
+	BaseURL     string
+	Credentials Credentials
+	HTTPClient  *http.Client
+	Logger      *slog.Logger
+	// Boundary разделитель частей multipart формы
+	Boundary  string
+	UserAgent string
+	// ListAction действие API со списком принятых файлов
+	ListAction string
+	// Header дополнительные заголовки каждого запроса
+	Header http.Header
+	// Codes описания известных кодов ответа PIRELLI, уточняют класс ошибки
+	Codes map[string]Code
+	// Observe вызывается после каждого ответа или ошибки транспорта (httpStatus 0)
+	Observe func(httpStatus int, response *Response, err *Error)
+}
+
+// New создает клиент с настройками по умолчанию: таймаут 30 секунд, boundary как в 1С
+func New(baseURL string, creds Credentials) *Client {
+	return &Client{
+		BaseURL:     baseURL,
+		Credentials: creds,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+		Logger:      slog.Default(),
+		Boundary:    DefaultBoundary,
+		UserAgent:   "Mozilla/5.0",
+		ListAction:  "list",
+	}
+}
+
+// FileName возвращает имя файла по формату PIRELLI: ir_<логин>_<дата>_<время>.csv
+func FileName(login string, t time.Time) string {
+	return fmt.Sprintf("ir_%s_%s.csv", login, t.Format("20060102_150405"))
+}
+
+// Upload отправляет файл действием upload. content читается потоком, size - его размер в байтах.
+// Ошибка - *Error, если файл не принят; ответ возвращается и вместе с ошибкой, если он пришел в JSON
+func (c *Client) Upload(ctx context.Context, fileName string, content io.Reader, size int64) (*Response, error) {
+	bodySize, err := c.UploadSize(fileName, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Форма пишется в канал по мере отправки: файл не загружается в память целиком
+	bodyReader, bodyWriter := io.Pipe()
+	defer bodyReader.Close()
+	go func() {
+		bodyWriter.CloseWithError(c.writeForm(bodyWriter, "upload", nil, fileName, content))
+	}()
+
+	// Тело запроса не логируем: в нем токен и содержимое файла
+	c.logger().Info("Отправка в PIRELLI", "url", c.BaseURL, "login", c.Credentials.Login, "file_name", fileName, "body_size", bodySize)
+	return c.post(ctx, bodyReader, bodySize)
+}
+
+// UploadSize возвращает размер тела запроса Upload для файла размером size
+func (c *Client) UploadSize(fileName string, size int64) (int64, error) {
+	// Служебная часть формы не зависит от содержимого файла
+	var overhead byteCounter
+	if err := c.writeForm(&overhead, "upload", nil, fileName, strings.NewReader("")); err != nil {
+		return 0, err
+	}
+	return int64(overhead) + size, nil
+}
+
+// Do выполняет действие API action без файла с дополнительными полями fields
+func (c *Client) Do(ctx context.Context, action string, fields ...Field) (*Response, error) {
+	var body bytes.Buffer
+	if err := c.writeForm(&body, action, fields, "", nil); err != nil {
+		return nil, err
+	}
+
+	c.logger().Info("Запрос к PIRELLI", "url", c.BaseURL, "login", c.Credentials.Login, "action", action)
+	return c.post(ctx, &body, int64(body.Len()))
+}
+
+// ListUploads возвращает файлы, принятые PIRELLI от логина клиента, в порядке ответа API
+func (c *Client) ListUploads(ctx context.Context) ([]UploadInfo, error) {
+	response, err := c.Do(ctx, c.ListAction)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// writeForm пишет multipart форму: action, данные аутентификации, fields и файл,
+// если задан fileName
+func (c *Client) writeForm(w io.Writer, action string, fields []Field, fileName string, content io.Reader) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(c.Boundary); err != nil {
+		return fmt.Errorf("неверный boundary формы: %v", err)
+	}
+
+	// Добавляем поля формы в ТОЧНОМ порядке как в примере
+	all := append([]Field{
+		{"action", action},
+		{"auth_login", c.Credentials.Login},
+		{"auth_token", c.Credentials.Token},
+	}, fields...)
+
+	for _, field := range all {
+		if err := writer.WriteField(field.Name, field.Value); err != nil {
+			return fmt.Errorf("ошибка добавления %s: %v", field.Name, err)
+		}
+	}
+
+	if fileName != "" {
+		// Создаем заголовок для файла с правильным Content-Type
+		headers := make(textproto.MIMEHeader)
+		headers.Set("Content-Disposition",
+			fmt.Sprintf(`form-data; name="file"; filename="%s"`, fileName))
+		headers.Set("Content-Type", "text/csv")
+
+		part, err := writer.CreatePart(headers)
+		if err != nil {
+			return fmt.Errorf("не удалось создать часть для файла: %v", err)
+		}
+
+		if _, err := io.Copy(part, content); err != nil {
+			return fmt.Errorf("не удалось скопировать содержимое файла: %v", err)
+		}
+	}
+
+	// Закрываем writer для завершения формы
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("ошибка при закрытии writer: %v", err)
+	}
+	return nil
+}
+
+// post отправляет форму и разбирает ответ. Возвращает ответ, если PIRELLI ответил JSON,
+// и *Error, если запрос не выполнен или PIRELLI его отклонил
+func (c *Client) post(ctx context.Context, body io.Reader, contentLength int64) (*Response, error) {
+	logger := c.logger()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания запроса: %v", err)
+	}
+	req.ContentLength = contentLength
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+c.Boundary)
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, c.observe(0, nil, &Error{
+			Class:   ClassTransport,
+			Message: "PIRELLI недоступен: " + err.Error(),
+			// Отмененный запрос повторять не нужно
+			Retryable: ctx.Err() == nil,
+			Err:       err,
+		})
+	}
+	defer resp.Body.Close()
+
+	// Читаем ответ
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, c.observe(0, nil, &Error{
+			Class:      ClassTransport,
+			HTTPStatus: resp.StatusCode,
+			Message:    "ответ PIRELLI не получен: " + err.Error(),
+			Retryable:  ctx.Err() == nil,
+			Err:        err,
+		})
+	}
+
+	logger.Info("Ответ от PIRELLI", "http_status", resp.StatusCode, "body_size", len(raw))
+	logger.Debug("Тело ответа PIRELLI", "body", string(raw))
+
+	// Парсим JSON ответ; HTML страница ошибки прокси или сервера в JSON не разбирается
+	var parsed *Response
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		parsed = nil
+	}
+
+	perr := classify(resp, parsed, c.Codes)
+	if perr == nil {
+		c.observe(resp.StatusCode, parsed, nil)
+		return parsed, nil
+	}
+	perr.RawBody = string(raw[:min(len(raw), rawBodyLimit)])
+	logger.Warn("PIRELLI вернул ошибку", "class", perr.Class, "http_status", perr.HTTPStatus, "code", perr.Code, "retryable", perr.Retryable, "body", perr.RawBody)
+	return parsed, c.observe(resp.StatusCode, parsed, perr)
+}
+
+// observe передает результат запроса в Observe и возвращает ошибку как error
+func (c *Client) observe(httpStatus int, response *Response, perr *Error) error {
+	if c.Observe != nil {
+		c.Observe(httpStatus, response, perr)
+	}
+	if perr == nil {
+		return nil
+	}
+	return perr
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
+}
+
+// byteCounter считает записанные байты, не сохраняя их
+type byteCounter int64
+
+func (b *byteCounter) Write(p []byte) (int, error) {
+	*b += byteCounter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,268 @@
+package pirelli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// formPart часть multipart формы, принятая тестовым сервером
+type formPart struct {
+	Name        string
+	FileName    string
+	ContentType string
+	Value       string
+}
+
+// receivedRequest запрос, принятый тестовым сервером
+type receivedRequest struct {
+	Header        http.Header
+	ContentLength int64
+	BodySize      int
+	Boundary      string
+	Parts         []formPart
+}
+
+// testServer поднимает заглушку PIRELLI, которая отвечает status, header и body и
+// запоминает последний запрос
+func testServer(t *testing.T, status int, header http.Header, body string) (*Client, *receivedRequest) {
+	t.Helper()
+	received := &receivedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("тело запроса не прочитано: %v", err)
+		}
+		received.Header = r.Header.Clone()
+		received.ContentLength = r.ContentLength
+		received.BodySize = len(raw)
+
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/form-data" {
+			t.Errorf("Content-Type %q", r.Header.Get("Content-Type"))
+		}
+		received.Boundary = params["boundary"]
+		reader := multipart.NewReader(strings.NewReader(string(raw)), received.Boundary)
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("форма не разбирается: %v", err)
+				break
+			}
+			value, _ := io.ReadAll(part)
+			received.Parts = append(received.Parts, formPart{
+				Name:        part.FormName(),
+				FileName:    part.FileName(),
+				ContentType: part.Header.Get("Content-Type"),
+				Value:       string(value),
+			})
+		}
+
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(server.URL, Credentials{Login: "5700097", Token: "secret-token"})
+	client.Logger = slog.New(slog.DiscardHandler)
+	return client, received
+}
+
+// fieldNames возвращает имена частей формы по порядку
+func (r *receivedRequest) fieldNames() string {
+	var names []string
+	for _, part := range r.Parts {
+		names = append(names, part.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestUpload(t *testing.T) {
+	client, received := testServer(t, http.StatusOK, nil, `{"status": true, "code": 0, "message": "Файл принят"}`)
+	client.Header = http.Header{"X-Request-Id": {"42"}}
+
+	const report = "sku,qty\n1,5\n"
+	response, err := client.Upload(context.Background(), "ir_5700097_20260101_090000.csv", strings.NewReader(report), int64(len(report)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Status || response.Message != "Файл принят" {
+		t.Errorf("ответ %+v", response)
+	}
+
+	if received.Boundary != DefaultBoundary {
+		t.Errorf("boundary %q", received.Boundary)
+	}
+	if got := received.Header.Get("User-Agent"); got != "Mozilla/5.0" {
+		t.Errorf("User-Agent %q", got)
+	}
+	if got := received.Header.Get("X-Request-Id"); got != "42" {
+		t.Errorf("дополнительный заголовок %q", got)
+	}
+	size, _ := client.UploadSize("ir_5700097_20260101_090000.csv", int64(len(report)))
+	if received.ContentLength != size || int64(received.BodySize) != size {
+		t.Errorf("Content-Length %d, тело %d байт, UploadSize %d", received.ContentLength, received.BodySize, size)
+	}
+
+	// Порядок полей как в примере PIRELLI
+	if got := received.fieldNames(); got != "action,auth_login,auth_token,file" {
+		t.Fatalf("поля формы %s", got)
+	}
+	for i, want := range []string{"upload", "5700097", "secret-token"} {
+		if received.Parts[i].Value != want {
+			t.Errorf("%s = %q", received.Parts[i].Name, received.Parts[i].Value)
+		}
+	}
+	file := received.Parts[3]
+	if file.FileName != "ir_5700097_20260101_090000.csv" || file.ContentType != "text/csv" || file.Value != report {
+		t.Errorf("файл %+v", file)
+	}
+}
+
+func TestDoFields(t *testing.T) {
+	client, received := testServer(t, http.StatusOK, nil, `{"status": true}`)
+	client.Boundary = "custom-boundary"
+
+	if _, err := client.Do(context.Background(), "status", Field{"file", "x.csv"}, Field{"page", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if received.Boundary != "custom-boundary" {
+		t.Errorf("boundary %q", received.Boundary)
+	}
+	if got := received.fieldNames(); got != "action,auth_login,auth_token,file,page" {
+		t.Fatalf("поля формы %s", got)
+	}
+	if received.Parts[0].Value != "status" || received.Parts[3].FileName != "" || received.Parts[4].Value != "2" {
+		t.Errorf("форма %+v", received.Parts)
+	}
+	if received.ContentLength != int64(received.BodySize) {
+		t.Errorf("Content-Length %d, тело %d байт", received.ContentLength, received.BodySize)
+	}
+}
+
+func TestListUploads(t *testing.T) {
+	client, received := testServer(t, http.StatusOK, nil, `{"status": true, "data": [
+		{"datetime": "2026-01-01 09:00:00", "original_name": "ir_5700097_20260101_090000.csv"},
+		{"datetime": "2026-01-02 09:00:00", "original_name": "ir_5700097_20260102_090000.csv"}]}`)
+	client.ListAction = "history"
+
+	uploads, err := client.ListUploads(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Parts[0].Value != "history" {
+		t.Errorf("action %q", received.Parts[0].Value)
+	}
+	if len(uploads) != 2 || uploads[1].OriginalName != "ir_5700097_20260102_090000.csv" || uploads[1].DateTime != "2026-01-02 09:00:00" {
+		t.Errorf("файлы %+v", uploads)
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		class      string
+		retryable  bool
+		retryAfter time.Duration
+		response   bool
+	}{
+		{"5xx", http.StatusBadGateway, nil, "<html>Bad Gateway</html>", ClassServer, true, 0, false},
+		{"401", http.StatusUnauthorized, nil, `{"status": false, "message": "denied"}`, ClassAuth, false, 0, true},
+		{"4xx", http.StatusBadRequest, nil, `{"status": false, "code": 17, "message": "wrong columns"}`, ClassValidation, false, 0, true},
+		{"429", http.StatusTooManyRequests, http.Header{"Retry-After": {"30"}}, `{"status": false}`, ClassRateLimited, true, 30 * time.Second, true},
+		{"status false", http.StatusOK, nil, `{"status": false, "code": 17, "message": "wrong columns"}`, ClassValidation, false, 0, true},
+		{"не JSON", http.StatusOK, nil, "<html>OK</html>", ClassServer, false, 0, false},
+		{"неполный JSON", http.StatusOK, nil, `{"status": tr`, ClassServer, false, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := testServer(t, tt.status, tt.header, tt.body)
+			var observed []int
+			client.Observe = func(httpStatus int, response *Response, err *Error) {
+				observed = append(observed, httpStatus)
+				if err == nil {
+					t.Error("Observe без ошибки")
+				}
+			}
+
+			response, err := client.Upload(context.Background(), "ir.csv", strings.NewReader("sku,qty\n"), 8)
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("ошибка %v (%T), ожидалась *Error", err, err)
+			}
+			if perr.Class != tt.class || perr.Retryable != tt.retryable || perr.RetryAfter != tt.retryAfter || perr.HTTPStatus != tt.status {
+				t.Errorf("ошибка %+v", perr)
+			}
+			if perr.RawBody != tt.body {
+				t.Errorf("RawBody %q", perr.RawBody)
+			}
+			if (response != nil) != tt.response {
+				t.Errorf("ответ %+v", response)
+			}
+			if len(observed) != 1 || observed[0] != tt.status {
+				t.Errorf("Observe вызван со статусами %v", observed)
+			}
+		})
+	}
+}
+
+func TestUploadCanceled(t *testing.T) {
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Сервер замечает закрытие соединения клиентом только после чтения тела
+		io.Copy(io.Discard, r.Body)
+		close(started)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	client := New(server.URL, Credentials{Login: "5700097", Token: "secret-token"})
+	var observed *Error
+	client.Observe = func(httpStatus int, response *Response, err *Error) { observed = err }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err := client.Upload(ctx, "ir.csv", strings.NewReader("sku,qty\n"), 8)
+
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Class != ClassTransport || perr.Retryable {
+		t.Fatalf("ошибка %+v, ожидалась transport без повтора", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ошибка не оборачивает context.Canceled: %v", perr.Err)
+	}
+	if observed != perr {
+		t.Error("Observe не получил ошибку транспорта")
+	}
+}
+
+func TestUploadUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	client := New(server.URL, Credentials{Login: "5700097", Token: "secret-token"})
+	_, err := client.Upload(context.Background(), "ir.csv", strings.NewReader("sku,qty\n"), 8)
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Class != ClassTransport || !perr.Retryable {
+		t.Fatalf("ошибка %+v, ожидалась transport с повтором", err)
+	}
+}
@@ -0,0 +1,202 @@
+package pirelli
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Классы ошибок обращения к PIRELLI
+const (
+	// ClassAuth неверный логин или токен
+	ClassAuth = "auth"
+	// ClassValidation PIRELLI отклонил файл или параметры запроса
+	ClassValidation = "validation"
+	// ClassRateLimited слишком частые запросы
+	ClassRateLimited = "rate_limited"
+	// ClassServer ошибка на стороне PIRELLI или непонятный ответ
+	ClassServer = "server"
+	// ClassTransport запрос не дошел или ответ не получен: сеть, таймаут, TLS
+	ClassTransport = "transport"
+)
+
+// Classes все классы ошибок
+var Classes = []string{ClassAuth, ClassValidation, ClassRateLimited, ClassServer, ClassTransport}
+
+// rawBodyLimit сколько байт ответа сохранять для диагностики
+const rawBodyLimit = 2048
+
+// Error ошибка обращения к PIRELLI с классом, признаком повторяемости и сырым ответом
+type Error struct {
+	Class      string `json:"class"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Code       int    `json:"code,omitempty"`
+	// Message понятное пользователю описание ошибки
+	Message string `json:"message"`
+	// Retryable повтор запроса позже может пройти успешно
+	Retryable bool `json:"retryable"`
+	// RetryAfter пауза из заголовка Retry-After ответа 429
+	RetryAfter time.Duration `json:"-"`
+	// RawBody начало тела ответа PIRELLI как есть
+	RawBody string `json:"raw_body,omitempty"`
+	Err     error  `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.HTTPStatus >= 300 {
+		return fmt.Sprintf("%s (HTTP %d)", e.Message, e.HTTPStatus)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorClass возвращает класс ошибки PIRELLI или пустую строку для других ошибок
+func ErrorClass(err error) string {
+	var perr *Error
+	if errors.As(err, &perr) {
+		return perr.Class
+	}
+	return ""
+}
+
+// Code описание известного кода ответа PIRELLI
+type Code struct {
+	Class   string
+	Message string
+}
+
+// DefaultCodes коды ответа PIRELLI, известные без настройки: API повторяет в поле code
+// статус HTTP. Client.Codes дополняет и переопределяет их
+var DefaultCodes = map[string]Code{
+	"401": {Class: ClassAuth},
+	"403": {Class: ClassAuth},
+	"429": {Class: ClassRateLimited},
+	"500": {Class: ClassServer},
+	"502": {Class: ClassServer},
+	"503": {Class: ClassServer},
+	"504": {Class: ClassServer},
+}
+
+// messagePatterns фрагменты сообщений PIRELLI (в нижнем регистре), по которым отказ с
+// неизвестным кодом относится к классу auth или rate_limited, а не validation
+var messagePatterns = []struct {
+	fragment string
+	class    string
+}{
+	{"неверный логин", ClassAuth},
+	{"неверный токен", ClassAuth},
+	{"ошибка авторизации", ClassAuth},
+	{"не авторизован", ClassAuth},
+	{"доступ запрещен", ClassAuth},
+	{"invalid login", ClassAuth},
+	{"invalid token", ClassAuth},
+	{"wrong login", ClassAuth},
+	{"wrong token", ClassAuth},
+	{"bad token", ClassAuth},
+	{"token expired", ClassAuth},
+	{"unauthorized", ClassAuth},
+	{"authentication failed", ClassAuth},
+	{"access denied", ClassAuth},
+	{"слишком много запросов", ClassRateLimited},
+	{"слишком часто", ClassRateLimited},
+	{"превышен лимит", ClassRateLimited},
+	{"too many requests", ClassRateLimited},
+	{"rate limit", ClassRateLimited},
+}
+
+// classMessages описание ошибки по классу, к которому добавляется сообщение PIRELLI
+var classMessages = map[string]string{
+	ClassAuth:        "PIRELLI не принял логин или токен",
+	ClassValidation:  "PIRELLI отклонил запрос",
+	ClassRateLimited: "PIRELLI ограничил частоту запросов",
+	ClassServer:      "ошибка сервера PIRELLI",
+}
+
+// knownCode возвращает описание кода ответа: из codes, из DefaultCodes или, для отказа
+// без известного кода, по фрагменту сообщения
+func knownCode(parsed *Response, codes map[string]Code) (Code, bool) {
+	code := strconv.Itoa(parsed.Code)
+	if known, ok := codes[code]; ok {
+		return known, true
+	}
+	if known, ok := DefaultCodes[code]; ok {
+		return known, true
+	}
+	message := strings.ToLower(parsed.Message)
+	for _, pattern := range messagePatterns {
+		if strings.Contains(message, pattern.fragment) {
+			return Code{Class: pattern.class}, true
+		}
+	}
+	return Code{}, false
+}
+
+// ParseCodes разбирает описания кодов в формате "код=класс:сообщение;..."
+func ParseCodes(value string) map[string]Code {
+	codes := map[string]Code{}
+	for _, rule := range strings.Split(value, ";") {
+		code, description, ok := strings.Cut(rule, "=")
+		code = strings.TrimSpace(code)
+		if !ok || code == "" {
+			continue
+		}
+		class, message, _ := strings.Cut(description, ":")
+		codes[code] = Code{Class: strings.TrimSpace(class), Message: strings.TrimSpace(message)}
+	}
+	return codes
+}
+
+// classify определяет класс ошибки по статусу HTTP и коду ответа PIRELLI; nil - запрос принят
+func classify(resp *http.Response, parsed *Response, codes map[string]Code) *Error {
+	status := resp.StatusCode
+	if parsed != nil && parsed.Status && status < 300 {
+		return nil
+	}
+
+	perr := &Error{HTTPStatus: status}
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		perr.Class = ClassAuth
+	case status == http.StatusTooManyRequests:
+		perr.Class = ClassRateLimited
+		perr.Retryable = true
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			perr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	case status >= 500:
+		perr.Class = ClassServer
+		perr.Retryable = true
+	case parsed == nil:
+		// Ответ 2xx не в JSON: неизвестно, принят ли файл, повтор может его задублировать
+		perr.Class = ClassServer
+		perr.Message = "PIRELLI вернул ответ не в формате JSON"
+		return perr
+	default:
+		perr.Class = ClassValidation
+	}
+	perr.Message = classMessages[perr.Class]
+
+	if parsed != nil {
+		perr.Code = parsed.Code
+		// Известные коды уточняют класс и описание
+		known, ok := knownCode(parsed, codes)
+		if ok {
+			perr.Class = known.Class
+			perr.Retryable = known.Class == ClassRateLimited || known.Class == ClassServer
+			perr.Message = classMessages[known.Class]
+		}
+		switch {
+		case known.Message != "":
+			perr.Message = known.Message
+		case parsed.Message != "":
+			perr.Message += ": " + parsed.Message
+		}
+	}
+	return perr
+}
@@ -0,0 +1,39 @@
+package pirelli
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClassifyCodes(t *testing.T) {
+	overrides := ParseCodes("17=validation:Неверный набор колонок;401=validation:Логин заблокирован")
+
+	for _, tt := range []struct {
+		name      string
+		status    int
+		parsed    *Response
+		codes     map[string]Code
+		class     string
+		retryable bool
+		message   string
+	}{
+		{"код 401 без настройки", 200, &Response{Code: 401, Message: "bad token"}, nil, ClassAuth, false, "PIRELLI не принял логин или токен: bad token"},
+		{"код 429 без настройки", 200, &Response{Code: 429}, nil, ClassRateLimited, true, "PIRELLI ограничил частоту запросов"},
+		{"код 503 без настройки", 200, &Response{Code: 503}, nil, ClassServer, true, "ошибка сервера PIRELLI"},
+		{"сообщение о токене", 200, &Response{Code: 5, Message: "Неверный токен"}, nil, ClassAuth, false, "PIRELLI не принял логин или токен: Неверный токен"},
+		{"сообщение о лимите", 200, &Response{Message: "Too many requests"}, nil, ClassRateLimited, true, "PIRELLI ограничил частоту запросов: Too many requests"},
+		{"неизвестный отказ", 200, &Response{Code: 17, Message: "wrong columns"}, nil, ClassValidation, false, "PIRELLI отклонил запрос: wrong columns"},
+		{"PIRELLI_CODES", 200, &Response{Code: 17, Message: "wrong columns"}, overrides, ClassValidation, false, "Неверный набор колонок"},
+		{"PIRELLI_CODES переопределяет код по умолчанию", 200, &Response{Code: 401}, overrides, ClassValidation, false, "Логин заблокирован"},
+		{"HTTP 401", 401, &Response{Message: "denied"}, nil, ClassAuth, false, "PIRELLI не принял логин или токен: denied"},
+		{"ответ не в JSON", 200, nil, nil, ClassServer, false, "PIRELLI вернул ответ не в формате JSON"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			perr := classify(resp, tt.parsed, tt.codes)
+			if perr.Class != tt.class || perr.Retryable != tt.retryable || perr.Message != tt.message {
+				t.Errorf("класс %q, retryable %v, сообщение %q", perr.Class, perr.Retryable, perr.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package pirelli
+
+// Response ответ API PIRELLI
+type Response struct {
+	Status  bool         `json:"status"`
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Data    []UploadInfo `json:"data"`
+}
+
+// UploadInfo файл, принятый PIRELLI
+type UploadInfo struct {
+	DateTime     string `json:"datetime"`
+	OriginalName string `json:"original_name"`
+}
@@ -0,0 +1,95 @@
+package pirelli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Transport настройки подключения к API PIRELLI
+type Transport struct {
+	// ProxyURL прокси http://, https://, socks5:// или socks5h://; пусто - из переменных
+	// окружения HTTPS_PROXY, HTTP_PROXY и NO_PROXY
+	ProxyURL string
+	// CAFiles дополнительные доверенные сертификаты (PEM) к системным, например CA прокси,
+	// который подменяет TLS сертификаты
+	CAFiles []string
+	// ClientCertFile и ClientKeyFile клиентский сертификат для подключения к PIRELLI
+	ClientCertFile string
+	ClientKeyFile  string
+	// MinTLSVersion минимальная версия TLS (tls.VersionTLS12, если не задана)
+	MinTLSVersion uint16
+	// ConnectTimeout время на TCP подключение и TLS рукопожатие
+	ConnectTimeout time.Duration
+	// ReadTimeout ожидание ответа после отправки запроса
+	ReadTimeout time.Duration
+	// Timeout ограничение всего запроса вместе с отправкой файла
+	Timeout time.Duration
+}
+
+// NewHTTPClient создает HTTP клиент по настройкам t. Клиент держит пул соединений:
+// его нужно переиспользовать между запросами, а не создавать на каждый
+func (t Transport) NewHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: t.MinTLSVersion}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	if len(t.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range t.CAFiles {
+			caPEM, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("не удалось прочитать CA: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, fmt.Errorf("в %s нет корректных сертификатов", file)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if t.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCertFile, t.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось загрузить клиентский сертификат: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if t.ProxyURL != "" {
+		proxyURL, err := url.Parse(t.ProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("некорректный адрес прокси")
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("прокси должен быть http, https, socks5 или socks5h, указан %q", proxyURL.Scheme)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{Timeout: t.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   t.ConnectTimeout,
+		ResponseHeaderTimeout: t.ReadTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{Transport: transport, Timeout: t.Timeout}, nil
+}
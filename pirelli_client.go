@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"sending-pirelli-stock/pirelli"
+)
+
+var (
+	// pirelliHTTP общий HTTP клиент PIRELLI, pirelliHTTPSettings - настройки, по которым он создан
+	pirelliHTTPMu       sync.Mutex
+	pirelliHTTP         *http.Client
+	pirelliHTTPSettings string
+)
+
+// pirelliTLSVersions допустимые значения PIRELLI_TLS_MIN_VERSION
+var pirelliTLSVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// pirelliTransport настройки подключения к PIRELLI из конфигурации c
+func pirelliTransport(c *Config) pirelli.Transport {
+	return pirelli.Transport{
+		ProxyURL:       c.PirelliProxyURL,
+		CAFiles:        c.PirelliCAFiles,
+		ClientCertFile: c.PirelliClientCertFile,
+		ClientKeyFile:  c.PirelliClientKeyFile,
+		MinTLSVersion:  pirelliTLSVersions[c.PirelliTLSMinVersion],
+		ConnectTimeout: c.PirelliConnectTimeout,
+		ReadTimeout:    c.PirelliReadTimeout,
+		Timeout:        c.PirelliTimeout,
+	}
+}
+
+// pirelliHTTPClient возвращает общий HTTP клиент PIRELLI: соединения переиспользуются между
+// запросами, клиент пересоздается, только когда меняются настройки подключения
+func pirelliHTTPClient() (*http.Client, error) {
+	transport := pirelliTransport(cfg())
+	settings := fmt.Sprintf("%+v", transport)
+
+	pirelliHTTPMu.Lock()
+	defer pirelliHTTPMu.Unlock()
+
+	if pirelliHTTP != nil && settings == pirelliHTTPSettings {
+		return pirelliHTTP, nil
+	}
+	client, err := transport.NewHTTPClient()
+	if err != nil {
+		return nil, fmt.Errorf("настройки подключения к PIRELLI: %v", err)
+	}
+	if pirelliHTTP != nil {
+		// Начатые запросы прежнего клиента завершатся, простаивающие соединения закрываем
+		pirelliHTTP.CloseIdleConnections()
+	}
+	pirelliHTTP, pirelliHTTPSettings = client, settings
+	return client, nil
+}
+
+// pirelliProxy возвращает прокси, через который идут запросы к target, или nil
+func pirelliProxy(target *url.URL) *url.URL {
+	client, err := pirelliHTTPClient()
+	if err != nil {
+		return nil
+	}
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		return nil
+	}
+	proxyURL, err := transport.Proxy(&http.Request{URL: target})
+	if err != nil {
+		return nil
+	}
+	return proxyURL
+}
+
+// pirelliTransportProblems возвращает ошибки в настройках подключения к PIRELLI
+func pirelliTransportProblems(c *Config) []string {
+	var problems []string
+	if _, ok := pirelliTLSVersions[c.PirelliTLSMinVersion]; !ok {
+		problems = append(problems, "PIRELLI_TLS_MIN_VERSION должен быть 1.2 или 1.3")
+	}
+	if (c.PirelliClientCertFile == "") != (c.PirelliClientKeyFile == "") {
+		problems = append(problems, "PIRELLI_CLIENT_CERT_FILE и PIRELLI_CLIENT_KEY_FILE задаются вместе")
+	}
+	if c.PirelliTimeout <= 0 {
+		problems = append(problems, "PIRELLI_TIMEOUT должен быть больше нуля")
+	}
+	return problems
+}
+
+// pirelliConnectionProblems проверяет прокси, файлы CA и сертификата созданием клиента.
+// Файлы читаются при каждом вызове, поэтому результат сохраняется в снимке конфигурации
+// при загрузке и перезагрузке
+func pirelliConnectionProblems(c *Config) []string {
+	if _, err := pirelliTransport(c).NewHTTPClient(); err != nil {
+		return []string{"Подключение к PIRELLI: " + err.Error()}
+	}
+	return nil
+}
+
+// newPirelliClient создает клиент PIRELLI по текущей конфигурации с логгером запроса и метриками
+func newPirelliClient(ctx context.Context, creds Credentials) (*pirelli.Client, error) {
+	httpClient, err := pirelliHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	c := cfg()
+	client := pirelli.New(c.BaseURL, pirelli.Credentials(creds))
+	client.HTTPClient = httpClient
+	client.Logger = loggerFrom(ctx)
+	client.Codes = c.PirelliCodes
+	client.ListAction = c.ListAction
+	client.Observe = func(httpStatus int, response *pirelli.Response, err *pirelli.Error) {
+		if httpStatus != 0 {
+			observeResponseCode(httpStatus, response)
+		}
+		if err != nil {
+			observePirelliError(err.Class)
+		}
+	}
+	return client, nil
+}
+
+// generatePirelliFilename генерирует имя файла по формату PIRELLI для логина login
+func generatePirelliFilename(login string) string {
+	return pirelli.FileName(login, time.Now())
+}
+
+// pirelliHint подсказка пользователю, что делать с ошибкой PIRELLI
+func pirelliHint(e *pirelli.Error) string {
+	switch {
+	case e.Class == pirelli.ClassAuth:
+		return "Проверьте логин и токен PIRELLI на странице /admin/token"
+	case e.RetryAfter > 0:
+		return fmt.Sprintf("Повторите отправку через %s", e.RetryAfter)
+	case e.Retryable:
+		return "Повторите отправку позже"
+	}
+	return ""
+}
+
+// pirelliCodeProblems возвращает ошибки в PIRELLI_CODES
+func pirelliCodeProblems(c *Config) []string {
+	var problems []string
+	for code, description := range c.PirelliCodes {
+		if _, err := strconv.Atoi(code); err != nil {
+			problems = append(problems, fmt.Sprintf("PIRELLI_CODES: код %q должен быть числом", code))
+		}
+		if !containsString(pirelli.Classes, description.Class) {
+			problems = append(problems, fmt.Sprintf("PIRELLI_CODES: неизвестный класс ошибки %q для кода %s", description.Class, code))
+		}
+	}
+	return problems
+}
+
+// handlePirelliUploads возвращает список файлов, принятых PIRELLI от текущего логина
+func handlePirelliUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "pirelli_uploads", "", "", auditDenied, "неверный пароль")
+		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+		return
+	}
+
+	creds := currentCredentials()
+	client, err := newPirelliClient(r.Context(), creds)
+	if err != nil {
+		recordAudit(r, "pirelli_uploads", creds.Login, "", auditFailure, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	uploads, err := client.ListUploads(r.Context())
+	if err != nil {
+		recordAudit(r, "pirelli_uploads", creds.Login, "", auditFailure, err.Error())
+		var perr *pirelli.Error
+		if errors.As(err, &perr) && perr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(perr.RetryAfter.Seconds())))
+		}
+		http.Error(w, "Ошибка запроса к PIRELLI: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	recordAudit(r, "pirelli_uploads", creds.Login, "", auditSuccess, fmt.Sprintf("файлов: %d", len(uploads)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Login     string               `json:"login"`
+		FetchedAt time.Time            `json:"fetched_at"`
+		Count     int                  `json:"count"`
+		Uploads   []pirelli.UploadInfo `json:"uploads"`
+	}{
+		Login:     creds.Login,
+		FetchedAt: time.Now(),
+		Count:     len(uploads),
+		Uploads:   append([]pirelli.UploadInfo{}, uploads...),
+	})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// reconcileGrace отправки моложе этого не сверяются: PIRELLI может показать файл в списке не сразу
+const reconcileGrace = 15 * time.Minute
+
+// reconcileCheckInterval как часто проверять, не пора ли выполнить сверку
+const reconcileCheckInterval = time.Minute
+
+// pirelliTimeLayout формат времени в списке файлов PIRELLI (часовой пояс PIRELLI_TIMEZONE)
+const pirelliTimeLayout = "2006-01-02 15:04:05"
+
+// ReconcileItem расхождение: файл есть только в нашей истории или только в списке PIRELLI
+type ReconcileItem struct {
+	Time     time.Time `json:"time"`
+	FileName string    `json:"file_name"`
+	// Trigger источник нашей отправки, пусто для файлов из списка PIRELLI
+	Trigger string `json:"trigger,omitempty"`
+}
+
+// ReconcileReport результат сверки истории отправок со списком файлов PIRELLI
+type ReconcileReport struct {
+	Time  time.Time `json:"time"`
+	Login string    `json:"login"`
+	Since time.Time `json:"since"`
+	// Local и Remote число отправок за период в нашей истории и в списке PIRELLI
+	Local  int `json:"local"`
+	Remote int `json:"remote"`
+	// Missing успешные по нашей истории отправки, которых нет в списке PIRELLI
+	Missing []ReconcileItem `json:"missing"`
+	// Unknown файлы из списка PIRELLI, которых нет среди наших успешных отправок
+	Unknown []ReconcileItem `json:"unknown"`
+	Error   string          `json:"error,omitempty"`
+}
+
+var (
+	// reconcileRunMu не дает сверкам по расписанию и по запросу выполняться одновременно
+	reconcileRunMu sync.Mutex
+	// reconcileAlerted расхождения, о которых уже отправлено уведомление (под reconcileRunMu)
+	reconcileAlerted = map[string]bool{}
+
+	lastReconcileMu sync.RWMutex
+	lastReconcile   *ReconcileReport
+)
+
+// lastReconcileReport возвращает результат последней сверки или nil
+func lastReconcileReport() *ReconcileReport {
+	lastReconcileMu.RLock()
+	defer lastReconcileMu.RUnlock()
+	return lastReconcile
+}
+
+// runReconciler выполняет сверку раз в RECONCILE_INTERVAL; интервал читается из
+// действующей конфигурации, поэтому перезагрузка применяется без перезапуска
+func runReconciler(ctx context.Context) {
+	ctx = withLogger(ctx, loggerFrom(ctx).With("task", "reconcile"))
+
+	ticker := time.NewTicker(reconcileCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		interval := cfg().ReconcileInterval
+		if interval <= 0 {
+			continue
+		}
+		if last := lastReconcileReport(); last != nil && time.Since(last.Time) < interval {
+			continue
+		}
+		runReconcile(ctx)
+	}
+}
+
+// runReconcile выполняет сверку, сохраняет результат и уведомляет о новых расхождениях
+func runReconcile(ctx context.Context) *ReconcileReport {
+	logger := loggerFrom(ctx)
+
+	reconcileRunMu.Lock()
+	defer reconcileRunMu.Unlock()
+
+	report := reconcile(ctx)
+	lastReconcileMu.Lock()
+	lastReconcile = report
+	lastReconcileMu.Unlock()
+
+	if report.Error != "" {
+		logger.Warn("Сверка с PIRELLI не выполнена", "error", report.Error)
+		return report
+	}
+
+	metricReconcileDiscrepancies.WithLabelValues("missing").Set(float64(len(report.Missing)))
+	metricReconcileDiscrepancies.WithLabelValues("unknown").Set(float64(len(report.Unknown)))
+	logger.Info("Сверка с PIRELLI выполнена", "local", report.Local, "remote", report.Remote,
+		"missing", len(report.Missing), "unknown", len(report.Unknown))
+
+	// Об одном и том же расхождении уведомляем один раз
+	current := map[string]bool{}
+	fresh := false
+	for kind, items := range map[string][]ReconcileItem{"missing": report.Missing, "unknown": report.Unknown} {
+		for _, item := range items {
+			key := kind + "/" + item.FileName + "/" + item.Time.String()
+			current[key] = true
+			fresh = fresh || !reconcileAlerted[key]
+		}
+	}
+	reconcileAlerted = current
+	if fresh {
+		notifyReconcileMismatch(ctx, report)
+	}
+	return report
+}
+
+// reconcile сравнивает успешные отправки текущего логина из журнала аудита за RECONCILE_WINDOW
+// со списком файлов PIRELLI. Файлы сопоставляются по имени, из одноименных - ближайший по времени
+func reconcile(ctx context.Context) *ReconcileReport {
+	c := cfg()
+	now := time.Now()
+	report := &ReconcileReport{
+		Time:    now,
+		Login:   currentCredentials().Login,
+		Since:   now.Add(-c.ReconcileWindow),
+		Missing: []ReconcileItem{},
+		Unknown: []ReconcileItem{},
+	}
+	until := now.Add(-reconcileGrace)
+	// Время файлов PIRELLI, которое не удалось разобрать, считаем попавшим в период
+	inWindow := func(t time.Time) bool {
+		return t.IsZero() || (!t.Before(report.Since) && !t.After(until))
+	}
+
+	entries, err := readUploadHistory(c.AuditLogPath, 0)
+	if err != nil {
+		report.Error = "ошибка чтения журнала аудита: " + err.Error()
+		return report
+	}
+	client, err := newPirelliClient(ctx, currentCredentials())
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	uploads, err := client.ListUploads(ctx)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	// Отправки чуть раньше периода тоже участвуют в сопоставлении, чтобы разница часов
+	// с PIRELLI на границе периода не давала ложных расхождений
+	// Записи без логина сделаны до того, как логин стал записываться в журнал; их
+	// относим к текущему логину
+	var local []ReconcileItem
+	for _, entry := range entries {
+		if entry.Outcome != auditSuccess || entry.Time.Before(report.Since.Add(-reconcileGrace)) {
+			continue
+		}
+		if entry.Login != "" && entry.Login != report.Login {
+			continue
+		}
+		local = append(local, ReconcileItem{Time: entry.Time, FileName: entry.Target, Trigger: uploadActionTrigger(entry.Action)})
+		if inWindow(entry.Time) {
+			report.Local++
+		}
+	}
+
+	location := c.PirelliTimezone
+	if location == nil {
+		location = time.Local
+	}
+	remote := make([]ReconcileItem, 0, len(uploads))
+	for _, upload := range uploads {
+		uploaded, err := time.ParseInLocation(pirelliTimeLayout, upload.DateTime, location)
+		if err != nil {
+			uploaded = time.Time{}
+		}
+		remote = append(remote, ReconcileItem{Time: uploaded, FileName: upload.OriginalName})
+		if inWindow(uploaded) {
+			report.Remote++
+		}
+	}
+
+	matched := make([]bool, len(remote))
+	for _, item := range local {
+		best := -1
+		for i, candidate := range remote {
+			if matched[i] || candidate.FileName != item.FileName {
+				continue
+			}
+			if best < 0 || absDuration(candidate.Time.Sub(item.Time)) < absDuration(remote[best].Time.Sub(item.Time)) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			matched[best] = true
+			continue
+		}
+		if inWindow(item.Time) {
+			report.Missing = append(report.Missing, item)
+		}
+	}
+	for i, item := range remote {
+		if !matched[i] && inWindow(item.Time) {
+			report.Unknown = append(report.Unknown, item)
+		}
+	}
+	return report
+}
+
+// absDuration возвращает модуль длительности
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// handlePirelliReconcile возвращает результат последней сверки (GET) или выполняет сверку сейчас (POST)
+func handlePirelliReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	action := "reconcile_view"
+	if r.Method == http.MethodPost {
+		action = "reconcile_run"
+	}
+	if !checkAdminPassword(r) {
+		recordAudit(r, action, "", "", auditDenied, "неверный пароль")
+		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+		return
+	}
+
+	report := lastReconcileReport()
+	if r.Method == http.MethodPost {
+		report = runReconcile(withLogger(r.Context(), loggerFrom(r.Context()).With("task", "reconcile")))
+	}
+	if report == nil {
+		recordAudit(r, action, "", "", auditFailure, "сверка еще не выполнялась")
+		http.Error(w, "Сверка еще не выполнялась", http.StatusNotFound)
+		return
+	}
+	if report.Error != "" {
+		recordAudit(r, action, report.Login, "", auditFailure, report.Error)
+	} else {
+		recordAudit(r, action, report.Login, "", auditSuccess,
+			fmt.Sprintf("missing: %d, unknown: %d", len(report.Missing), len(report.Unknown)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Error != "" {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(report)
+}
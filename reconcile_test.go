@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconcileCurrentLogin(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	sent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	foreign := sent.Add(-time.Minute)
+
+	// PIRELLI отдает время в своем часовом поясе
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status": true, "data": [
+			{"datetime": %q, "original_name": "ir_5700097_1.csv"},
+			{"datetime": %q, "original_name": "ir_5700097_other.csv"}]}`,
+			sent.In(moscow).Format(pirelliTimeLayout), foreign.In(moscow).Format(pirelliTimeLayout))
+	}))
+	defer server.Close()
+
+	setTestConfig(t, &Config{
+		BaseURL:              server.URL,
+		AuthLogin:            "5700097",
+		AuthToken:            strings.Repeat("a", 64),
+		AuditLogPath:         filepath.Join(t.TempDir(), "audit.log"),
+		PirelliTLSMinVersion: "1.2",
+		ListAction:           "list",
+		ReconcileWindow:      24 * time.Hour,
+		PirelliTimezone:      moscow,
+	})
+	previous := audit
+	t.Cleanup(func() { audit = previous })
+	var err error
+	if audit, err = openAuditLog(cfg().AuditLogPath); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []AuditEntry{
+		{Login: "5700097", Target: "ir_5700097_1.csv"},
+		// Отправка от другого логина в сверку не входит
+		{Login: "5700098", Target: "ir_5700098_1.csv"},
+		// Запись версии без логина относится к текущему логину
+		{Target: "ir_5700097_old.csv"},
+	} {
+		entry.Time, entry.Actor, entry.Action, entry.Outcome = sent, "scheduler", "scheduled_upload", auditSuccess
+		writeAudit(entry)
+	}
+
+	report := reconcile(context.Background())
+	if report.Error != "" {
+		t.Fatal(report.Error)
+	}
+	if report.Local != 2 || report.Remote != 2 {
+		t.Errorf("local %d, remote %d", report.Local, report.Remote)
+	}
+	if len(report.Missing) != 1 || report.Missing[0].FileName != "ir_5700097_old.csv" {
+		t.Errorf("missing %+v", report.Missing)
+	}
+	if len(report.Unknown) != 1 || report.Unknown[0].FileName != "ir_5700097_other.csv" || !report.Unknown[0].Time.Equal(foreign) {
+		t.Errorf("unknown %+v, ожидалось время %v", report.Unknown, foreign)
+	}
+}
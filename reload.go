@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// restartConfigKeys параметры, которые применяются только после перезапуска
+var restartConfigKeys = map[string]bool{
+	"SERVER_PORT":              true,
+	"HTTP_REDIRECT_PORT":       true,
+	"TLS_CERT_FILE":            true,
+	"TLS_KEY_FILE":             true,
+	"TLS_CLIENT_CA_FILE":       true,
+	"TLS_CLIENT_CERT_REQUIRED": true,
+	"TLS_SELF_SIGNED_HOSTS":    true,
+	"READ_HEADER_TIMEOUT":      true,
+	"READ_TIMEOUT":             true,
+	"WRITE_TIMEOUT":            true,
+	"IDLE_TIMEOUT":             true,
+	"MAX_HEADER_BYTES":         true,
+	"JOB_WORKERS":              true,
+	"JOB_QUEUE_SIZE":           true,
+	"LOG_FORMAT":               true,
+	"LOG_FILE":                 true,
+	"LOG_MAX_SIZE_MB":          true,
+	"LOG_MAX_BACKUPS":          true,
+	"AUDIT_LOG_PATH":           true,
+	"AUDIT_HMAC_KEY":           true,
+	"CONFIG_WATCH_INTERVAL":    true,
+	"ASSETS_DIR":               true,
+}
+
+// configReload результат перезагрузки конфигурации
+type configReload struct {
+	// Changed измененные параметры
+	Changed []string
+	// Restart измененные параметры, которые применятся после перезапуска
+	Restart []string
+}
+
+// summary описывает изменения для лога и журнала аудита
+func (r *configReload) summary() string {
+	if len(r.Changed) == 0 {
+		return "изменений нет"
+	}
+	text := "изменены: " + strings.Join(r.Changed, ", ")
+	if len(r.Restart) > 0 {
+		text += "; требуется перезапуск: " + strings.Join(r.Restart, ", ")
+	}
+	return text
+}
+
+// reloadConfig перечитывает конфигурацию, проверяет ее и атомарно публикует новый снимок.
+// При ошибках действующая конфигурация не меняется
+func reloadConfig() (*configReload, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	old := currentConfig.Load()
+	next, err := readConfig(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Токен, активированный через ротацию, сохраняется, если в источниках он не менялся
+	if next.loadedValue("AUTH_LOGIN") == old.loadedValue("AUTH_LOGIN") && next.loadedValue("AUTH_TOKEN") == old.loadedValue("AUTH_TOKEN") {
+		next.Config.AuthLogin = old.Config.AuthLogin
+		next.Config.AuthToken = old.Config.AuthToken
+	}
+
+	next.ConnectionProblems = pirelliConnectionProblems(next.Config)
+	if problems := next.storedProblems(); len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	result := diffConfig(old, next)
+	keepRestartSettings(next.Config, old.Config)
+
+	currentConfig.Store(next)
+
+	var level slog.Level
+	if level.UnmarshalText([]byte(next.Config.LogLevel)) == nil {
+		logLevel.Set(level)
+	}
+	rearmScheduler()
+
+	return result, nil
+}
+
+// loadedValue возвращает значение параметра из источников конфигурации
+func (s *configSnapshot) loadedValue(key string) string {
+	for _, value := range s.Values {
+		if value.Key == key {
+			return value.Value
+		}
+	}
+	return ""
+}
+
+// valueMap возвращает действующие значения параметров с учетом ротации токена
+func (s *configSnapshot) valueMap() map[string]string {
+	values := make(map[string]string, len(s.Values))
+	for _, value := range s.Values {
+		values[value.Key] = value.Value
+	}
+	values["AUTH_LOGIN"] = s.Config.AuthLogin
+	values["AUTH_TOKEN"] = s.Config.AuthToken
+	return values
+}
+
+// diffConfig возвращает измененные параметры
+func diffConfig(old, next *configSnapshot) *configReload {
+	oldValues := old.valueMap()
+	nextValues := next.valueMap()
+
+	keys := map[string]bool{}
+	for key := range oldValues {
+		keys[key] = true
+	}
+	for key := range nextValues {
+		keys[key] = true
+	}
+
+	result := &configReload{}
+	for key := range keys {
+		if oldValues[key] == nextValues[key] {
+			continue
+		}
+		result.Changed = append(result.Changed, key)
+		if restartConfigKeys[key] {
+			result.Restart = append(result.Restart, key)
+		}
+	}
+	sort.Strings(result.Changed)
+	sort.Strings(result.Restart)
+	return result
+}
+
+// keepRestartSettings оставляет действующими параметры, которые нельзя применить без перезапуска
+func keepRestartSettings(next, old *Config) {
+	next.ServerPort = old.ServerPort
+	next.HTTPRedirectPort = old.HTTPRedirectPort
+	next.TLSCertFile = old.TLSCertFile
+	next.TLSKeyFile = old.TLSKeyFile
+	next.TLSClientCAFile = old.TLSClientCAFile
+	next.TLSClientCertRequired = old.TLSClientCertRequired
+	next.TLSSelfSignedHosts = old.TLSSelfSignedHosts
+	next.ReadHeaderTimeout = old.ReadHeaderTimeout
+	next.ReadTimeout = old.ReadTimeout
+	next.WriteTimeout = old.WriteTimeout
+	next.IdleTimeout = old.IdleTimeout
+	next.MaxHeaderBytes = old.MaxHeaderBytes
+	next.JobWorkers = old.JobWorkers
+	next.JobQueueSize = old.JobQueueSize
+	next.LogFormat = old.LogFormat
+	next.LogFile = old.LogFile
+	next.LogMaxSizeMB = old.LogMaxSizeMB
+	next.LogMaxBackups = old.LogMaxBackups
+	next.AuditLogPath = old.AuditLogPath
+	// Ключ подписи .head меняется только с перезапуском, как и путь журнала
+	next.AuditHMACKey = old.AuditHMACKey
+	next.ConfigWatchInterval = old.ConfigWatchInterval
+	next.AssetsDir = old.AssetsDir
+}
+
+// runReload перезагружает конфигурацию, пишет результат в лог и журнал аудита.
+// r - запрос администратора или nil для сигнала и изменения файла
+func runReload(reason string, r *http.Request) (*configReload, error) {
+	result, err := reloadConfig()
+	target := currentConfig.Load().File
+
+	if err != nil {
+		slog.Error("Конфигурация не перезагружена, действует прежняя", "reason", reason, "error", err)
+		recordReloadAudit(r, target, auditFailure, reason+": "+err.Error())
+		return nil, err
+	}
+
+	// Изменение файла без изменения значений (например, сохранение токена) не записываем
+	if reason == "file_change" && len(result.Changed) == 0 {
+		return result, nil
+	}
+
+	slog.Info("Конфигурация перезагружена", "reason", reason, "changed", strings.Join(result.Changed, ","), "restart_required", strings.Join(result.Restart, ","))
+	recordReloadAudit(r, target, auditSuccess, reason+": "+result.summary())
+	return result, nil
+}
+
+// recordReloadAudit записывает перезагрузку в журнал аудита
+func recordReloadAudit(r *http.Request, target, outcome, details string) {
+	if r != nil {
+		recordAudit(r, "config_reload", target, "", outcome, details)
+		return
+	}
+	recordActorAudit("system", "config_reload", target, "", outcome, details)
+}
+
+// reloadOnSignal перезагружает конфигурацию по SIGHUP
+func reloadOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-signals:
+			runReload("SIGHUP", nil)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watchConfig перезагружает конфигурацию при изменении файла конфигурации или .env
+func watchConfig(ctx context.Context) {
+	interval := cfg().ConfigWatchInterval
+	if interval <= 0 {
+		return
+	}
+
+	last := configFilesState()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			state := configFilesState()
+			if state == last {
+				continue
+			}
+			last = state
+			runReload("file_change", nil)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// configFilesState возвращает время изменения и размер файлов конфигурации
+func configFilesState() string {
+	configPath := currentConfig.Load().File
+	if configPath == "" {
+		configPath = defaultConfigFile
+	}
+
+	var state strings.Builder
+	for _, path := range []string{configPath, envFilePath} {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&state, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return state.String()
+}
+
+// handleConfigReload перезагружает конфигурацию по запросу администратора
+func handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "config_reload", "", "", auditDenied, "неверный пароль")
+		sendWebResult(w, false, "Неверный пароль")
+		return
+	}
+
+	result, err := runReload("api", r)
+	if err != nil {
+		sendWebResult(w, false, "Конфигурация не перезагружена", err.Error())
+		return
+	}
+	sendWebResult(w, true, "Конфигурация перезагружена", result.summary())
+}
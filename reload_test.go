@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReloadKeepsAuditHMACKey(t *testing.T) {
+	t.Setenv("AUDIT_HMAC_KEY", "")
+	base := "AUTH_LOGIN=5700097\nAUTH_TOKEN=" + strings.Repeat("a", 64) + "\nADMIN_PASSWORD=secret\n"
+	settingsTestEnv(t, base+"AUDIT_HMAC_KEY=old-key\n")
+
+	if err := os.WriteFile(envFilePath, []byte(base+"AUDIT_HMAC_KEY=new-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(result.Restart, "AUDIT_HMAC_KEY") {
+		t.Errorf("AUDIT_HMAC_KEY не помечен как требующий перезапуска: %v", result.Restart)
+	}
+	if cfg().AuditHMACKey != "old-key" {
+		t.Errorf("ключ подписи журнала сменился без перезапуска: %q", cfg().AuditHMACKey)
+	}
+}
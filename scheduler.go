@@ -1,48 +1,191 @@
 package main
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
 	"time"
 )
 
-// startScheduler запускает планировщик автоматической отправки
-func startScheduler() {
-	log.Printf("Планировщик запущен. Отправка в %s, день недели: %d", config.UploadTime, config.UploadDay)
+// schedulerLateThreshold опоздание, после которого отправка считается пропущенной в срок
+const schedulerLateThreshold = 5 * time.Minute
+
+// schedulerRearm сигнал планировщику пересчитать время отправки после перезагрузки конфигурации
+var schedulerRearm = make(chan struct{}, 1)
+
+// rearmScheduler просит планировщик пересчитать время следующей отправки
+func rearmScheduler() {
+	select {
+	case schedulerRearm <- struct{}{}:
+	default:
+	}
+}
+
+// startScheduler запускает планировщик автоматической отправки и работает до отмены ctx
+func startScheduler(ctx context.Context) {
+	logger := slog.Default().With("trigger", "scheduler")
+	logger.Info("Планировщик запущен", "upload_time", cfg().UploadTime, "upload_day", cfg().UploadDay)
+
+	checkMissedRun(withLogger(ctx, logger))
 
 	for {
 		now := time.Now()
 
-		// Парсим время отправки
-		uploadTime, err := time.Parse("15:04", config.UploadTime)
-		if err != nil {
-			log.Printf("Ошибка парсинга времени: %v", err)
-			time.Sleep(1 * time.Hour)
+		// Вычисляем время следующей отправки по текущей конфигурации
+		var timer *time.Timer
+		var wait <-chan time.Time
+		nextUpload, err := nextScheduledRun(now)
+		switch {
+		case cfg().UploadTime == "":
+			logger.Info("Автоматическая отправка выключена")
+			metricSchedulerNextRun.Set(0)
+		case err != nil:
+			logger.Error("Ошибка парсинга времени", "error", err)
+			wait = time.After(1 * time.Hour)
+		default:
+			logger.Info("Следующая автоматическая отправка", "next_upload", nextUpload.Format("2006-01-02 15:04:05"))
+			metricSchedulerNextRun.Set(float64(nextUpload.Unix()))
+			timer = time.NewTimer(nextUpload.Sub(now))
+			wait = timer.C
+		}
+
+		// Ждем до времени отправки или перезагрузки конфигурации
+		rearmed := false
+		select {
+		case <-wait:
+		case <-schedulerRearm:
+			rearmed = true
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			logger.Info("Планировщик остановлен")
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if rearmed {
+			logger.Info("Конфигурация изменена, расписание пересчитано")
+			continue
+		}
+		if err != nil || cfg().UploadTime == "" {
 			continue
 		}
 
-		// Создаем время следующей отправки
-		nextUpload := time.Date(now.Year(), now.Month(), now.Day(),
-			uploadTime.Hour(), uploadTime.Minute(), 0, 0, now.Location())
+		// Выполняем отправку; начатая отправка не прерывается сигналом остановки
+		runCtx := withTrigger(withLogger(context.WithoutCancel(ctx), slog.Default().With("request_id", newRequestID())), triggerScheduler)
+		if late := time.Since(nextUpload); late > schedulerLateThreshold {
+			reason := fmt.Sprintf("отправка запущена с опозданием на %s (компьютер был в спящем режиме?)", late.Round(time.Minute))
+			loggerFrom(runCtx).Warn("Автоматическая отправка запущена с опозданием", "scheduled", nextUpload.Format("2006-01-02 15:04:05"), "late", late.Round(time.Second).String())
+			notifySchedulerMissed(runCtx, nextUpload, reason)
+		}
+		runScheduledUpload(runCtx, cfg())
+	}
+}
+
+// runScheduledUpload ставит отправку файла CSV_FILE_PATH из снимка конфигурации в очередь
+// и ждет ее завершения
+func runScheduledUpload(ctx context.Context, c *Config) {
+	logger := loggerFrom(ctx)
+	logger.Info("Выполняется автоматическая отправка отчета", "path", c.CSVFilePath)
+	if info, err := os.Stat(c.CSVFilePath); err == nil && c.SourceMaxAge > 0 {
+		if age := time.Since(info.ModTime()); age > c.SourceMaxAge {
+			logger.Warn("Файл для отправки устарел", "path", c.CSVFilePath, "age", age.Round(time.Minute).String())
+			notifySourceStale(ctx, c.CSVFilePath, age)
+		}
+	}
 
-		// Если время уже прошло сегодня, планируем на следующий день
-		if now.After(nextUpload) || (now.Weekday() != time.Weekday(config.UploadDay) && config.UploadDay >= 0) {
-			daysToAdd := (config.UploadDay - int(now.Weekday()) + 7) % 7
-			if daysToAdd == 0 && now.After(nextUpload) {
-				daysToAdd = 7
-			}
-			nextUpload = nextUpload.AddDate(0, 0, daysToAdd)
+	job := newSourceJob(ctx, nil, "scheduled_upload", c.CSVFilePath)
+	if err := submitJob(job); err != nil {
+		logger.Error("Ошибка автоматической отправки", "error", err)
+		recordSchedulerAudit("scheduled_upload", job.FileName, job.uploadChecksum, auditFailure, err.Error())
+		return
+	}
+	<-job.done
+
+	if result := job.status().Result; result != nil && !result.Success {
+		logger.Error("Ошибка автоматической отправки", "job_id", job.ID, "error", result.Message)
+	}
+}
+
+// newSourceJob создает задание отправки файла filePath под его собственным именем;
+// r - запрос инициатора или nil для планировщика
+func newSourceJob(ctx context.Context, r *http.Request, action, filePath string) *uploadJob {
+	job := newUploadJob(ctx, r, action, filepath.Base(filePath))
+	job.filePath = filePath
+	job.sendAs = csvFileName(job.FileName)
+	job.checksum = fileSHA256(filePath)
+	job.uploadChecksum = job.checksum
+	job.report(JobEvent{Phase: phaseReceived, Message: "Файл для отправки: " + filePath})
+	return job
+}
+
+// nextScheduledRun возвращает время ближайшей автоматической отправки после now
+func nextScheduledRun(now time.Time) (time.Time, error) {
+	c := cfg()
+	uploadTime, err := time.Parse("15:04", c.UploadTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	nextUpload := time.Date(now.Year(), now.Month(), now.Day(),
+		uploadTime.Hour(), uploadTime.Minute(), 0, 0, now.Location())
+
+	// Если время уже прошло сегодня, планируем на следующий день
+	if now.After(nextUpload) || (now.Weekday() != time.Weekday(c.UploadDay) && c.UploadDay >= 0) {
+		daysToAdd := (c.UploadDay - int(now.Weekday()) + 7) % 7
+		if daysToAdd == 0 && now.After(nextUpload) {
+			daysToAdd = 7
 		}
+		nextUpload = nextUpload.AddDate(0, 0, daysToAdd)
+	}
+
+	return nextUpload, nil
+}
+
+// checkMissedRun уведомляет, если плановая отправка не выполнялась, пока сервер не работал
+func checkMissedRun(ctx context.Context) {
+	lastRun := lastScheduledRun(cfg().AuditLogPath)
+	if lastRun.IsZero() {
+		// Автоматических отправок еще не было, сравнивать не с чем
+		return
+	}
 
-		// Ждем до времени отправки
-		duration := nextUpload.Sub(now)
-		log.Printf("Следующая автоматическая отправка: %s", nextUpload.Format("2006-01-02 15:04:05"))
+	nextUpload, err := nextScheduledRun(time.Now())
+	if err != nil {
+		return
+	}
+	previous := nextUpload.AddDate(0, 0, -7)
 
-		time.Sleep(duration)
+	if lastRun.Before(previous) {
+		loggerFrom(ctx).Warn("Пропущена автоматическая отправка", "scheduled", previous.Format("2006-01-02 15:04:05"), "last_run", lastRun.Format("2006-01-02 15:04:05"))
+		notifySchedulerMissed(withTrigger(ctx, triggerScheduler), previous,
+			fmt.Sprintf("сервер не работал в плановое время, последняя автоматическая отправка: %s", lastRun.Local().Format("2006-01-02 15:04")))
+	}
+}
+
+// lastScheduledRun возвращает время последней автоматической отправки по журналу аудита
+func lastScheduledRun(path string) time.Time {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer file.Close()
 
-		// Выполняем отправку
-		log.Println("Выполняется автоматическая отправка отчета...")
-		if err := uploadFile(config.CSVFilePath); err != nil {
-			log.Printf("Ошибка автоматической отправки: %v", err)
+	var last time.Time
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Action == "scheduled_upload" {
+			last = entry.Time
 		}
 	}
+	return last
 }
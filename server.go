@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Состояния сервера для /api/status
+const (
+	stateRunning int32 = iota
+	stateDraining
+	stateStopped
+)
+
+var (
+	serverState atomic.Int32
+
+	// backgroundWG отслеживает фоновые задачи (планировщик), которых ждем при остановке
+	backgroundWG sync.WaitGroup
+)
+
+// serverStateName возвращает название текущего состояния сервера
+func serverStateName() string {
+	switch serverState.Load() {
+	case stateDraining:
+		return "draining"
+	case stateStopped:
+		return "stopped"
+	default:
+		return "running"
+	}
+}
+
+// rejectIfDraining отклоняет новые отправки, пока сервер останавливается
+func rejectIfDraining(w http.ResponseWriter) bool {
+	if serverState.Load() == stateRunning {
+		return false
+	}
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "Сервер останавливается, повторите позже", http.StatusServiceUnavailable)
+	return true
+}
+
+// tlsEnabled сообщает, включен ли HTTPS
+func tlsEnabled() bool {
+	return cfg().TLSCertFile != ""
+}
+
+// runServer запускает HTTP сервер и корректно останавливает его после отмены ctx
+func runServer(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              ":" + cfg().ServerPort,
+		Handler:           withRequestID(http.DefaultServeMux),
+		ReadHeaderTimeout: cfg().ReadHeaderTimeout,
+		ReadTimeout:       cfg().ReadTimeout,
+		WriteTimeout:      cfg().WriteTimeout,
+		IdleTimeout:       cfg().IdleTimeout,
+		MaxHeaderBytes:    cfg().MaxHeaderBytes,
+	}
+
+	scheme := "http"
+	if tlsEnabled() {
+		if cfg().TLSKeyFile == "" {
+			return fmt.Errorf("ошибка конфигурации TLS: TLS_KEY_FILE не указан")
+		}
+		tlsConfig, err := buildTLSConfig()
+		if err != nil {
+			return fmt.Errorf("ошибка конфигурации TLS: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+		scheme = "https"
+	}
+
+	// Запускаем сервер
+	baseURL := fmt.Sprintf("%s://localhost:%s", scheme, cfg().ServerPort)
+	slog.Info("Сервер запущен",
+		"company", cfg().CompanyName,
+		"port", cfg().ServerPort,
+		"web_form", baseURL+"/",
+		"status", baseURL+"/api/status",
+		"upload_api", baseURL+"/api/upload")
+
+	if cfg().UploadTime != "" {
+		slog.Info("Автоматическая отправка", "upload_time", cfg().UploadTime, "upload_day", cfg().UploadDay)
+	}
+
+	serveErr := make(chan error, 2)
+
+	go func() {
+		var err error
+		if tlsEnabled() {
+			if cfg().TLSClientCAFile != "" {
+				slog.Info("Клиентские сертификаты для /api/upload", "ca_file", cfg().TLSClientCAFile, "required", cfg().TLSClientCertRequired)
+			}
+			// Сертификат и ключ берутся из TLSConfig.GetCertificate
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		serveErr <- err
+	}()
+
+	// Перенаправление с HTTP на HTTPS
+	var redirectServer *http.Server
+	if tlsEnabled() && cfg().HTTPRedirectPort != "" {
+		redirectServer = &http.Server{
+			Addr:              ":" + cfg().HTTPRedirectPort,
+			Handler:           http.HandlerFunc(redirectToHTTPS),
+			ReadHeaderTimeout: cfg().ReadHeaderTimeout,
+			MaxHeaderBytes:    cfg().MaxHeaderBytes,
+		}
+		go func() {
+			slog.Info("Перенаправление HTTP -> HTTPS", "port", cfg().HTTPRedirectPort)
+			serveErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Перестаем принимать новые отправки, но какое-то время продолжаем отвечать,
+	// чтобы балансировщик успел увидеть состояние draining
+	serverState.Store(stateDraining)
+	slog.Info("Получен сигнал остановки, завершаем работу", "shutdown_timeout", cfg().ShutdownTimeout.String())
+	time.Sleep(cfg().DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg().ShutdownTimeout)
+	defer cancel()
+
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+
+	// Shutdown закрывает слушатели и ждет завершения активных запросов
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Не все запросы завершились до истечения времени ожидания", "error", err)
+	}
+
+	// Новые задания больше не поступают; обработчики доделывают те, что уже в очереди
+	uploadPool.stop()
+
+	// Ждем планировщик, если он выполняет отправку, и отправку уведомлений
+	done := make(chan struct{})
+	go func() {
+		backgroundWG.Wait()
+		notifyWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		slog.Warn("Фоновые задачи не завершились до истечения времени ожидания")
+	}
+
+	serverState.Store(stateStopped)
+	slog.Info("Сервер остановлен")
+	return nil
+}
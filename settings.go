@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SettingField параметр, который можно изменить на странице настроек
+type SettingField struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	Group string `json:"group"`
+	// Kind тип поля: text, time, day, duration, list, bool, secret
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	// Locked значение задано переменной окружения и из .env не переопределяется
+	Locked bool `json:"locked"`
+	// Optional пустое значение допустимо и выключает функцию (расписание, канал уведомлений)
+	Optional bool `json:"optional"`
+}
+
+// settingFields параметры страницы настроек в порядке отображения
+var settingFields = []SettingField{
+	{Key: "UPLOAD_TIME", Label: "Время отправки (ЧЧ:ММ)", Group: "Расписание", Kind: "time", Optional: true},
+	{Key: "UPLOAD_DAY", Label: "День недели (0 - воскресенье ... 6 - суббота)", Group: "Расписание", Kind: "day"},
+
+	{Key: "CSV_FILE_PATH", Label: "Путь к CSV файлу", Group: "Источник", Kind: "text"},
+	{Key: "SOURCE_MAX_AGE", Label: "Допустимый возраст файла", Group: "Источник", Kind: "duration"},
+	{Key: "DEDUPE_WINDOW", Label: "Не отправлять тот же файл повторно в течение", Group: "Источник", Kind: "duration"},
+
+	{Key: "NOTIFY_EMAIL_TO", Label: "Получатели писем (через запятую)", Group: "Уведомления", Kind: "list", Optional: true},
+	{Key: "SMTP_HOST", Label: "SMTP сервер", Group: "Уведомления", Kind: "text", Optional: true},
+	{Key: "SMTP_PORT", Label: "SMTP порт", Group: "Уведомления", Kind: "text"},
+	{Key: "SMTP_USERNAME", Label: "SMTP пользователь", Group: "Уведомления", Kind: "text", Optional: true},
+	{Key: "SMTP_PASSWORD", Label: "SMTP пароль", Group: "Уведомления", Kind: "secret", Optional: true},
+	{Key: "SMTP_FROM", Label: "Адрес отправителя", Group: "Уведомления", Kind: "text", Optional: true},
+	{Key: "SMTP_IMPLICIT_TLS", Label: "SMTP через TLS (порт 465)", Group: "Уведомления", Kind: "bool"},
+	{Key: "TELEGRAM_BOT_TOKEN", Label: "Токен Telegram бота", Group: "Уведомления", Kind: "secret", Optional: true},
+	{Key: "TELEGRAM_CHAT_ID", Label: "Telegram чаты (через запятую)", Group: "Уведомления", Kind: "list", Optional: true},
+	{Key: "WEBHOOK_URL", Label: "Webhook URL", Group: "Уведомления", Kind: "secret", Optional: true},
+	{Key: "NOTIFY_ROUTES", Label: "Маршруты (событие=каналы;...)", Group: "Уведомления", Kind: "text", Optional: true},
+	{Key: "NOTIFY_RATE_LIMIT", Label: "Не чаще одного уведомления за", Group: "Уведомления", Kind: "duration"},
+}
+
+// settingsMu не дает двум сохранениям настроек переписывать .env одновременно
+var settingsMu sync.Mutex
+
+// findSettingField возвращает описание параметра страницы настроек
+func findSettingField(key string) (SettingField, bool) {
+	for _, field := range settingFields {
+		if field.Key == key {
+			return field, true
+		}
+	}
+	return SettingField{}, false
+}
+
+// optionalSetting сообщает, можно ли очистить параметр на странице настроек. Для таких
+// параметров пустое значение в .env действует, а не заменяется значением по умолчанию
+func optionalSetting(key string) bool {
+	field, ok := findSettingField(key)
+	return ok && field.Optional
+}
+
+// currentSettings возвращает параметры страницы настроек с текущими значениями и источниками
+func currentSettings() []SettingField {
+	values := map[string]ConfigValue{}
+	for _, value := range currentConfig.Load().effectiveValues() {
+		values[value.Key] = value
+	}
+
+	fields := make([]SettingField, 0, len(settingFields))
+	for _, field := range settingFields {
+		value := values[field.Key]
+		field.Value = value.Value
+		field.Source = value.Source
+		field.Locked = value.Source == sourceEnv
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// settingsChanges собирает измененные в форме параметры. Пустой секрет означает "не менять",
+// очистить секрет можно, перечислив его в поле clear
+func settingsChanges(r *http.Request) (map[string]string, error) {
+	snapshot := currentConfig.Load()
+	changes := map[string]string{}
+
+	for _, key := range r.Form["clear"] {
+		if field, ok := findSettingField(key); !ok || !field.Optional {
+			return nil, fmt.Errorf("%s: параметр нельзя очистить", key)
+		}
+	}
+
+	for _, field := range settingFields {
+		if _, ok := r.Form[field.Key]; !ok {
+			continue
+		}
+		value := strings.TrimSpace(r.FormValue(field.Key))
+		if field.Kind == "secret" && value == "" && !containsString(r.Form["clear"], field.Key) {
+			continue
+		}
+		if value == snapshot.loadedValue(field.Key) {
+			continue
+		}
+
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("%s: значение не может содержать перевод строки", field.Key)
+		}
+		if value == "" && !field.Optional {
+			return nil, fmt.Errorf("%s: значение не может быть пустым", field.Key)
+		}
+		changes[field.Key] = value
+	}
+
+	for key := range r.Form {
+		if _, ok := findSettingField(key); !ok && key != "password" && key != "clear" {
+			return nil, fmt.Errorf("параметр %s нельзя изменить на странице настроек", key)
+		}
+	}
+
+	return changes, nil
+}
+
+// envFileValue записывает значение для .env: в кавычках, если в нем есть пробелы или спецсимволы
+func envFileValue(value string) string {
+	if !strings.ContainsAny(value, " \t#'\"\\$`") {
+		return value
+	}
+	if !strings.Contains(value, "'") {
+		// В одинарных кавычках godotenv не разбирает экранирование и переменные
+		return "'" + value + "'"
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// saveSettings проверяет изменения вместе с остальной конфигурацией и сохраняет их в .env
+func saveSettings(changes map[string]string) error {
+	snapshot := currentConfig.Load()
+	for key := range changes {
+		for _, value := range snapshot.Values {
+			if value.Key == key && value.Source == sourceEnv {
+				return fmt.Errorf("%s задан переменной окружения и не может быть изменен", key)
+			}
+		}
+	}
+
+	candidate, err := readConfig(changes)
+	if err != nil {
+		return err
+	}
+	if problems := candidate.validate(); len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	values := make(map[string]string, len(changes))
+	for key, value := range changes {
+		values[key] = envFileValue(value)
+	}
+	return updateEnvFile(envFilePath, values)
+}
+
+// handleSettingsPage отображает страницу настроек
+func handleSettingsPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tmplData := struct {
+		CompanyName string
+	}{
+		CompanyName: cfg().CompanyName,
+	}
+
+	renderPage(w, "settings.html", tmplData)
+}
+
+// handleSettings возвращает (GET) или сохраняет и применяет (POST) настройки
+func handleSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !checkAdminPassword(r) {
+			http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			EnvFile string         `json:"env_file"`
+			Fields  []SettingField `json:"fields"`
+		}{
+			EnvFile: envFilePath,
+			Fields:  currentSettings(),
+		})
+
+	case http.MethodPost:
+		if !checkAdminPassword(r) {
+			recordAudit(r, "settings_update", "", "", auditDenied, "неверный пароль")
+			sendWebResult(w, false, "Неверный пароль")
+			return
+		}
+
+		if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+			sendWebResult(w, false, "Ошибка разбора формы: "+err.Error())
+			return
+		}
+
+		settingsMu.Lock()
+		defer settingsMu.Unlock()
+
+		changes, err := settingsChanges(r)
+		if err != nil {
+			sendWebResult(w, false, "Настройки не сохранены", err.Error())
+			return
+		}
+		if len(changes) == 0 {
+			sendWebResult(w, true, "Изменений нет")
+			return
+		}
+
+		keys := make([]string, 0, len(changes))
+		for key := range changes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		// В журнал пишем только имена параметров: среди значений могут быть секреты
+		if err := saveSettings(changes); err != nil {
+			recordAudit(r, "settings_update", envFilePath, "", auditFailure, strings.Join(keys, ", ")+": "+err.Error())
+			sendWebResult(w, false, "Настройки не сохранены", err.Error())
+			return
+		}
+		loggerFrom(r.Context()).Info("Настройки сохранены", "keys", strings.Join(keys, ","))
+		recordAudit(r, "settings_update", envFilePath, "", auditSuccess, strings.Join(keys, ", "))
+
+		result, err := runReload("settings", r)
+		if err != nil {
+			sendWebResult(w, false, "Настройки сохранены, но не применены", err.Error())
+			return
+		}
+		sendWebResult(w, true, "Настройки сохранены и применены", result.summary())
+
+	default:
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	}
+}
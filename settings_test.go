@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// settingsTestEnv переходит во временный каталог с .env и загружает из него конфигурацию
+func settingsTestEnv(t *testing.T, dotenv string) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	for _, key := range []string{"UPLOAD_TIME", "CSV_FILE_PATH", "WEBHOOK_URL", "TELEGRAM_BOT_TOKEN"} {
+		t.Setenv(key, "")
+	}
+	if err := os.WriteFile(envFilePath, []byte(dotenv), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := currentConfig.Load()
+	t.Cleanup(func() { currentConfig.Store(previous) })
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// settingsForm разбирает форму страницы настроек
+func settingsForm(t *testing.T, form url.Values) map[string]string {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/admin/settings", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := settingsChanges(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestSettingsClearOptional(t *testing.T) {
+	settingsTestEnv(t, "UPLOAD_TIME=08:00\nWEBHOOK_URL=https://hooks.example.com/x\nTELEGRAM_BOT_TOKEN=123:abc\n")
+
+	changes := settingsForm(t, url.Values{
+		"UPLOAD_TIME":        {""},
+		"WEBHOOK_URL":        {""},
+		"TELEGRAM_BOT_TOKEN": {""},
+		"clear":              {"WEBHOOK_URL"},
+	})
+	if len(changes) != 2 || changes["UPLOAD_TIME"] != "" || changes["WEBHOOK_URL"] != "" {
+		t.Fatalf("изменения %v, ожидались пустые UPLOAD_TIME и WEBHOOK_URL", changes)
+	}
+	if err := saveSettings(changes); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(envFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"UPLOAD_TIME=\n", "WEBHOOK_URL=\n", "TELEGRAM_BOT_TOKEN=123:abc\n"} {
+		if !strings.Contains(string(content), line) {
+			t.Errorf("в .env нет строки %q:\n%s", line, content)
+		}
+	}
+
+	// Пустое значение в .env выключает расписание и канал, а не возвращает значение по умолчанию
+	snapshot, err := readConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Config.UploadTime != "" || snapshot.Config.WebhookURL != "" {
+		t.Errorf("UPLOAD_TIME %q, WEBHOOK_URL %q после очистки", snapshot.Config.UploadTime, snapshot.Config.WebhookURL)
+	}
+	if snapshot.Config.TelegramBotToken != "123:abc" {
+		t.Errorf("пустое поле секрета без clear изменило TELEGRAM_BOT_TOKEN: %q", snapshot.Config.TelegramBotToken)
+	}
+}
+
+func TestSettingsRequiredNotEmpty(t *testing.T) {
+	settingsTestEnv(t, "CSV_FILE_PATH=./stock.csv\n")
+
+	r := httptest.NewRequest("POST", "/api/admin/settings", strings.NewReader("CSV_FILE_PATH="))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ParseForm()
+	if _, err := settingsChanges(r); err == nil {
+		t.Fatal("пустой CSV_FILE_PATH принят")
+	}
+
+	// Пустая строка в .env для обязательного параметра означает значение по умолчанию
+	os.WriteFile(envFilePath, []byte("CSV_FILE_PATH=\n"), 0600)
+	snapshot, err := readConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Config.CSVFilePath != "./report.csv" {
+		t.Errorf("CSV_FILE_PATH %q", snapshot.Config.CSVFilePath)
+	}
+}
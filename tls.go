@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certReloader отдает сертификат сервера и перечитывает его при изменении файлов
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader загружает сертификат и ключ из файлов
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload перечитывает сертификат и ключ
+func (c *certReloader) reload() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("не удалось загрузить сертификат: %v", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.mu.Unlock()
+	return nil
+}
+
+// latestModTime возвращает время последнего изменения сертификата или ключа
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("не удалось прочитать %s: %v", path, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate реализует tls.Config.GetCertificate с горячей перезагрузкой
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if modTime, err := c.latestModTime(); err == nil {
+		c.mu.RLock()
+		changed := modTime.After(c.modTime)
+		c.mu.RUnlock()
+
+		if changed {
+			if err := c.reload(); err != nil {
+				// Оставляем прежний сертификат, пока файлы не станут корректными
+				slog.Error("Ошибка перезагрузки сертификата, используется прежний", "error", err)
+			} else {
+				slog.Info("Сертификат перезагружен", "cert_file", c.certFile)
+			}
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// buildTLSConfig создает TLS конфигурацию сервера
+func buildTLSConfig() (*tls.Config, error) {
+	if err := ensureCertificate(cfg().TLSCertFile, cfg().TLSKeyFile); err != nil {
+		return nil, err
+	}
+
+	reloader, err := newCertReloader(cfg().TLSCertFile, cfg().TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if cfg().TLSClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg().TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать CA клиентских сертификатов: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("в %s нет корректных сертификатов", cfg().TLSClientCAFile)
+		}
+
+		// Клиентский сертификат проверяется при наличии, а обязательность
+		// определяется обработчиком /api/upload
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// ensureCertificate создает самоподписанный сертификат, если файлов еще нет
+func ensureCertificate(certFile, keyFile string) error {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+	if !os.IsNotExist(certErr) && certErr != nil {
+		return fmt.Errorf("не удалось прочитать %s: %v", certFile, certErr)
+	}
+	if !os.IsNotExist(keyErr) && keyErr != nil {
+		return fmt.Errorf("не удалось прочитать %s: %v", keyFile, keyErr)
+	}
+
+	slog.Warn("Сертификат не найден, создаем самоподписанный", "cert_file", certFile)
+	return generateSelfSignedCert(certFile, keyFile, cfg().TLSSelfSignedHosts)
+}
+
+// generateSelfSignedCert создает самоподписанный сертификат для указанных имен и адресов
+func generateSelfSignedCert(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("ошибка генерации ключа: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("ошибка генерации серийного номера: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cfg().CompanyName, Organization: []string{cfg().CompanyName}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("ошибка создания сертификата: %v", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации ключа: %v", err)
+	}
+
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return fmt.Errorf("не удалось создать каталог для %s: %v", path, err)
+		}
+	}
+
+	// Ключ пишем первым: наличие сертификата без ключа хуже, чем наоборот
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("не удалось записать ключ: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("не удалось записать сертификат: %v", err)
+	}
+
+	return nil
+}
+
+// defaultSelfSignedHosts возвращает имена для самоподписанного сертификата по умолчанию
+func defaultSelfSignedHosts() string {
+	hosts := []string{"localhost", "127.0.0.1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	return strings.Join(hosts, ",")
+}
+
+// redirectToHTTPS перенаправляет запросы по HTTP на HTTPS порт сервера
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if cfg().ServerPort != "443" {
+		host = net.JoinHostPort(host, cfg().ServerPort)
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// clientCertName возвращает CN проверенного клиентского сертификата
+func clientCertName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// checkUploadAuth проверяет доступ к /api/upload по клиентскому сертификату или паролю
+func checkUploadAuth(r *http.Request) bool {
+	if cfg().TLSClientCAFile != "" {
+		if clientCertName(r) != "" {
+			return true
+		}
+		if cfg().TLSClientCertRequired {
+			return false
+		}
+	}
+	return checkAdminPassword(r)
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// envFilePath путь к .env файлу, в который сохраняются активированные данные
+const envFilePath = ".env"
+
+// Credentials данные аутентификации PIRELLI
+type Credentials struct {
+	Login string
+	Token string
+}
+
+// StagedCredentials новые данные аутентификации, ожидающие проверки и активации
+type StagedCredentials struct {
+	Credentials
+	StagedAt   time.Time
+	VerifiedAt time.Time
+	VerifyErr  string
+}
+
+// TokenState состояние ротации токена для API и веб-интерфейса
+type TokenState struct {
+	Current        TokenInfo  `json:"current"`
+	Staged         *TokenInfo `json:"staged,omitempty"`
+	Previous       *TokenInfo `json:"previous,omitempty"`
+	StagedAt       string     `json:"staged_at,omitempty"`
+	StagedVerified bool       `json:"staged_verified"`
+	VerifiedAt     string     `json:"verified_at,omitempty"`
+	VerifyError    string     `json:"verify_error,omitempty"`
+}
+
+// TokenInfo замаскированное представление данных аутентификации
+type TokenInfo struct {
+	Login string `json:"login"`
+	Token string `json:"token"`
+}
+
+var (
+	credMu              sync.RWMutex
+	stagedCredentials   *StagedCredentials
+	previousCredentials *Credentials
+)
+
+// currentCredentials возвращает действующие данные аутентификации
+func currentCredentials() Credentials {
+	c := cfg()
+	return Credentials{Login: c.AuthLogin, Token: c.AuthToken}
+}
+
+// setCurrentCredentials публикует снимок конфигурации с новыми данными аутентификации, вызывается под credMu
+func setCurrentCredentials(creds Credentials) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	snapshot := *currentConfig.Load()
+	updated := *snapshot.Config
+	updated.AuthLogin = creds.Login
+	updated.AuthToken = creds.Token
+	snapshot.Config = &updated
+	currentConfig.Store(&snapshot)
+}
+
+// stageCredentials сохраняет новые данные аутентификации для последующей проверки
+func stageCredentials(login, token string) error {
+	login = strings.TrimSpace(login)
+	token = strings.TrimSpace(token)
+
+	if login == "" {
+		return fmt.Errorf("логин не указан")
+	}
+	if len(token) != 64 {
+		return fmt.Errorf("длина токена %d, ожидается 64 символа", len(token))
+	}
+
+	credMu.Lock()
+	defer credMu.Unlock()
+
+	stagedCredentials = &StagedCredentials{
+		Credentials: Credentials{Login: login, Token: token},
+		StagedAt:    time.Now(),
+	}
+	return nil
+}
+
+// verifyStagedCredentials проверяет подготовленные данные запросом к API PIRELLI
+func verifyStagedCredentials(ctx context.Context) error {
+	credMu.RLock()
+	if stagedCredentials == nil {
+		credMu.RUnlock()
+		return fmt.Errorf("нет подготовленных данных для проверки")
+	}
+	staged := stagedCredentials.Credentials
+	credMu.RUnlock()
+
+	verifyErr := verifyPirelliCredentials(ctx, staged)
+
+	credMu.Lock()
+	defer credMu.Unlock()
+
+	// Данные могли быть заменены, пока шла проверка
+	if stagedCredentials == nil || stagedCredentials.Credentials != staged {
+		return fmt.Errorf("подготовленные данные изменились во время проверки")
+	}
+
+	if verifyErr != nil {
+		stagedCredentials.VerifiedAt = time.Time{}
+		stagedCredentials.VerifyErr = verifyErr.Error()
+		return verifyErr
+	}
+
+	stagedCredentials.VerifiedAt = time.Now()
+	stagedCredentials.VerifyErr = ""
+	return nil
+}
+
+// activateStagedCredentials переключает сервер на проверенные данные, сохраняя старые для отката
+func activateStagedCredentials() (Credentials, error) {
+	credMu.Lock()
+	defer credMu.Unlock()
+
+	if stagedCredentials == nil {
+		return Credentials{}, fmt.Errorf("нет подготовленных данных для активации")
+	}
+	if stagedCredentials.VerifiedAt.IsZero() {
+		return Credentials{}, fmt.Errorf("подготовленные данные не прошли проверку")
+	}
+
+	old := Credentials{Login: cfg().AuthLogin, Token: cfg().AuthToken}
+	previousCredentials = &old
+	setCurrentCredentials(stagedCredentials.Credentials)
+	stagedCredentials = nil
+
+	return Credentials{Login: cfg().AuthLogin, Token: cfg().AuthToken}, nil
+}
+
+// rollbackCredentials возвращает предыдущие данные аутентификации
+func rollbackCredentials() (Credentials, error) {
+	credMu.Lock()
+	defer credMu.Unlock()
+
+	if previousCredentials == nil {
+		return Credentials{}, fmt.Errorf("нет предыдущих данных для отката")
+	}
+
+	current := Credentials{Login: cfg().AuthLogin, Token: cfg().AuthToken}
+	setCurrentCredentials(*previousCredentials)
+	previousCredentials = &current
+
+	return Credentials{Login: cfg().AuthLogin, Token: cfg().AuthToken}, nil
+}
+
+// tokenState возвращает текущее состояние ротации токена
+func tokenState() TokenState {
+	credMu.RLock()
+	defer credMu.RUnlock()
+
+	state := TokenState{
+		Current: maskCredentials(Credentials{Login: cfg().AuthLogin, Token: cfg().AuthToken}),
+	}
+
+	if stagedCredentials != nil {
+		staged := maskCredentials(stagedCredentials.Credentials)
+		state.Staged = &staged
+		state.StagedAt = stagedCredentials.StagedAt.Format("2006-01-02 15:04:05")
+		state.StagedVerified = !stagedCredentials.VerifiedAt.IsZero()
+		if state.StagedVerified {
+			state.VerifiedAt = stagedCredentials.VerifiedAt.Format("2006-01-02 15:04:05")
+		}
+		state.VerifyError = stagedCredentials.VerifyErr
+	}
+
+	if previousCredentials != nil {
+		previous := maskCredentials(*previousCredentials)
+		state.Previous = &previous
+	}
+
+	return state
+}
+
+// maskCredentials скрывает токен, оставляя первые и последние символы
+func maskCredentials(creds Credentials) TokenInfo {
+	return TokenInfo{Login: creds.Login, Token: maskSecret(creds.Token)}
+}
+
+// maskSecret скрывает секрет, оставляя первые и последние 4 символа
+func maskSecret(secret string) string {
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + strings.Repeat("*", len(secret)-8) + secret[len(secret)-4:]
+}
+
+// verifyPirelliCredentials выполняет безопасный запрос к API PIRELLI без отправки файла
+func verifyPirelliCredentials(ctx context.Context, creds Credentials) error {
+	loggerFrom(ctx).Info("Проверка данных аутентификации", "login", creds.Login, "action", cfg().VerifyAction)
+
+	client, err := newPirelliClient(ctx, creds)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(ctx, cfg().VerifyAction)
+	return err
+}
+
+// persistCredentials сохраняет действующие данные аутентификации в .env файл
+func persistCredentials(creds Credentials) error {
+	return updateEnvFile(envFilePath, map[string]string{
+		"AUTH_LOGIN": creds.Login,
+		"AUTH_TOKEN": creds.Token,
+	})
+}
+
+// updateEnvFile заменяет значения ключей в .env файле, сохраняя комментарии и порядок строк
+func updateEnvFile(path string, values map[string]string) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("не удалось прочитать %s: %v", path, err)
+	}
+
+	var lines []string
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	}
+
+	written := make(map[string]bool)
+	for i, line := range lines {
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "export ")
+		key, _, found := strings.Cut(trimmed, "=")
+		if !found || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if value, ok := values[key]; ok {
+			lines[i] = key + "=" + value
+			written[key] = true
+		}
+	}
+
+	for key, value := range values {
+		if !written[key] {
+			lines = append(lines, key+"="+value)
+		}
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы не оставить файл наполовину записанным
+	tempFile, err := os.CreateTemp(filepath.Dir(path), ".env-*")
+	if err != nil {
+		return fmt.Errorf("не удалось создать временный файл: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("не удалось записать временный файл: %v", err)
+	}
+	if err := tempFile.Chmod(0600); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("не удалось установить права на файл: %v", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("не удалось закрыть временный файл: %v", err)
+	}
+
+	return os.Rename(tempFile.Name(), path)
+}
+
+// handleTokenPage отображает страницу ротации токена
+func handleTokenPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tmplData := struct {
+		CompanyName string
+	}{
+		CompanyName: cfg().CompanyName,
+	}
+
+	renderPage(w, "token.html", tmplData)
+}
+
+// handleTokenStatus возвращает состояние ротации токена
+func handleTokenStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "token_status", "", "", auditDenied, "неверный пароль")
+		http.Error(w, "Неверный пароль", http.StatusUnauthorized)
+		return
+	}
+
+	recordAudit(r, "token_status", "", "", auditSuccess, "")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokenState())
+}
+
+// handleTokenStage принимает новые логин и токен для проверки
+func handleTokenStage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "token_stage", "", "", auditDenied, "неверный пароль")
+		sendWebResult(w, false, "Неверный пароль")
+		return
+	}
+
+	login := r.FormValue("login")
+	if login == "" {
+		login = currentCredentials().Login
+	}
+
+	if err := stageCredentials(login, r.FormValue("token")); err != nil {
+		recordAudit(r, "token_stage", login, "", auditFailure, err.Error())
+		sendWebResult(w, false, "Ошибка подготовки токена: "+err.Error())
+		return
+	}
+
+	loggerFrom(r.Context()).Info("Подготовлен новый токен", "login", login)
+	recordAudit(r, "token_stage", login, "", auditSuccess, "")
+	sendWebResult(w, true, "Новый токен подготовлен, выполните проверку")
+}
+
+// handleTokenVerify проверяет подготовленный токен запросом к PIRELLI
+func handleTokenVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "token_verify", "", "", auditDenied, "неверный пароль")
+		sendWebResult(w, false, "Неверный пароль")
+		return
+	}
+
+	if err := verifyStagedCredentials(r.Context()); err != nil {
+		loggerFrom(r.Context()).Warn("Проверка нового токена не пройдена", "error", err)
+		recordAudit(r, "token_verify", "", "", auditFailure, err.Error())
+		sendWebResult(w, false, "Проверка не пройдена: "+err.Error())
+		return
+	}
+
+	loggerFrom(r.Context()).Info("Новый токен прошел проверку")
+	recordAudit(r, "token_verify", "", "", auditSuccess, "")
+	sendWebResult(w, true, "Проверка пройдена, токен можно активировать")
+}
+
+// handleTokenActivate переключает сервер на проверенный токен
+func handleTokenActivate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "token_activate", "", "", auditDenied, "неверный пароль")
+		sendWebResult(w, false, "Неверный пароль")
+		return
+	}
+
+	creds, err := activateStagedCredentials()
+	if err != nil {
+		recordAudit(r, "token_activate", "", "", auditFailure, err.Error())
+		sendWebResult(w, false, "Ошибка активации: "+err.Error())
+		return
+	}
+
+	loggerFrom(r.Context()).Info("Активирован новый токен", "login", creds.Login)
+	recordAudit(r, "token_activate", creds.Login, "", auditSuccess, "токен "+maskSecret(creds.Token))
+
+	if err := persistCredentials(creds); err != nil {
+		loggerFrom(r.Context()).Error("Не удалось сохранить токен", "path", envFilePath, "error", err)
+		sendWebResult(w, true, "Токен активирован", "Не удалось сохранить в "+envFilePath+": "+err.Error())
+		return
+	}
+
+	sendWebResult(w, true, "Токен активирован", "Предыдущий токен сохранен для отката")
+}
+
+// handleTokenRollback возвращает предыдущий токен
+func handleTokenRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminPassword(r) {
+		recordAudit(r, "token_rollback", "", "", auditDenied, "неверный пароль")
+		sendWebResult(w, false, "Неверный пароль")
+		return
+	}
+
+	creds, err := rollbackCredentials()
+	if err != nil {
+		recordAudit(r, "token_rollback", "", "", auditFailure, err.Error())
+		sendWebResult(w, false, "Ошибка отката: "+err.Error())
+		return
+	}
+
+	loggerFrom(r.Context()).Info("Выполнен откат токена", "login", creds.Login)
+	recordAudit(r, "token_rollback", creds.Login, "", auditSuccess, "токен "+maskSecret(creds.Token))
+
+	if err := persistCredentials(creds); err != nil {
+		loggerFrom(r.Context()).Error("Не удалось сохранить токен", "path", envFilePath, "error", err)
+		sendWebResult(w, true, "Откат выполнен", "Не удалось сохранить в "+envFilePath+": "+err.Error())
+		return
+	}
+
+	sendWebResult(w, true, "Откат выполнен")
+}
@@ -2,17 +2,6 @@ package main
 
 import "time"
 
-// PirelliResponse структура для ответа от PIRELLI
-type PirelliResponse struct {
-	Status  bool   `json:"status"`
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Data    []struct {
-		DateTime     string `json:"datetime"`
-		OriginalName string `json:"original_name"`
-	} `json:"data"`
-}
-
 // ServerStatus структура для статуса сервера
 type ServerStatus struct {
 	Status     string    `json:"status"`
@@ -20,6 +9,10 @@ type ServerStatus struct {
 	Company    string    `json:"company"`
 	Login      string    `json:"login"`
 	NextUpload string    `json:"next_upload,omitempty"`
+
+	Health     string        `json:"health"`
+	Checks     []HealthCheck `json:"checks"`
+	LastUpload *UploadRecord `json:"last_upload,omitempty"`
 }
 
 // UploadResult результат загрузки через веб-форму
@@ -27,4 +20,6 @@ type UploadResult struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	Details string `json:"details,omitempty"`
+	// JobID задание фоновой обработки загрузки
+	JobID string `json:"job_id,omitempty"`
 }
@@ -1,40 +1,39 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
-	"mime/multipart"
+	"log/slog"
 	"net/http"
-	"net/textproto"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
+
+	"sending-pirelli-stock/pirelli"
 )
 
 // calculateNextUploadTime вычисляет время следующей автоматической отправки
 func calculateNextUploadTime() string {
-	now := time.Now()
-	uploadTime, err := time.Parse("15:04", config.UploadTime)
+	nextUpload, err := nextScheduledRun(time.Now())
 	if err != nil {
 		return ""
 	}
+	return nextUpload.Format("2006-01-02 15:04:05")
+}
 
-	nextUpload := time.Date(now.Year(), now.Month(), now.Day(),
-		uploadTime.Hour(), uploadTime.Minute(), 0, 0, now.Location())
-
-	if now.After(nextUpload) || (now.Weekday() != time.Weekday(config.UploadDay) && config.UploadDay >= 0) {
-		daysToAdd := (config.UploadDay - int(now.Weekday()) + 7) % 7
-		if daysToAdd == 0 && now.After(nextUpload) {
-			daysToAdd = 7
-		}
-		nextUpload = nextUpload.AddDate(0, 0, daysToAdd)
+// checkAdminPassword проверяет пароль администратора из заголовка или формы
+func checkAdminPassword(r *http.Request) bool {
+	password := r.Header.Get("X-Admin-Password")
+	if password == "" {
+		password = r.FormValue("password")
 	}
-
-	return nextUpload.Format("2006-01-02 15:04:05")
+	// Сравнение за постоянное время не выдает совпадающее начало пароля по времени ответа
+	return password != "" && subtle.ConstantTimeCompare([]byte(password), []byte(cfg().AdminPassword)) == 1
 }
 
 // sendWebResult отправляет результат веб-загрузки
@@ -52,246 +51,225 @@ func sendWebResult(w http.ResponseWriter, success bool, message string, details
 	json.NewEncoder(w).Encode(result)
 }
 
-// validateCSVFile с логированием для отладки
-func validateCSVFile(file io.Reader) error {
-	content, err := io.ReadAll(file)
-	if err != nil {
-		return fmt.Errorf("ошибка чтения файла: %v", err)
-	}
+// validateChunkSize размер блока, которым читается проверяемый файл
+const validateChunkSize = 64 * 1024
+
+// dangerousPatterns опасные паттерны для Linux сервера (в нижнем регистре)
+var dangerousPatterns = []string{
+	// Shell injection
+	"$((", "`", "&&", "||", "|", ">", "<", ";",
+	// Command execution
+	"/bin/bash", "/bin/sh", "bash -c", "sh -c", "eval ", "exec(",
+	// System commands
+	"rm -rf", "rm -f", "chmod", "chown", "sudo", "su ",
+	"wget", "curl", "nc ", "netcat", "ssh ", "scp ",
+	// File system access
+	"/etc/passwd", "/etc/shadow", "/etc/hosts", "/proc/",
+	"../../", "../etc/", "/root/", "/home/",
+	// Network
+	"127.0.0.1", "localhost", "0.0.0.0",
+	// Code injection
+	"<script", "javascript:", "vbscript:", "onload=", "onerror=",
+	"<iframe", "<object", "<embed",
+	// SQL injection (базовые)
+	"union select", "drop table", "insert into", "delete from",
+	"update set", "create table", "alter table",
+	// PHP injection
+	"<?php", "<?=", "system(", "shell_exec(", "exec(",
+	"passthru(", "proc_open", "popen(",
+}
 
-	log.Printf("Размер файла: %d байт", len(content))
+// maxUploadBytes максимальный размер загружаемого файла из MAX_UPLOAD_SIZE_MB
+func maxUploadBytes() int64 {
+	return int64(cfg().MaxUploadSizeMB) << 20
+}
 
-	// Проверяем размер (10MB максимум)
-	if len(content) > 10*1024*1024 {
-		return fmt.Errorf("файл слишком большой (максимум 10MB)")
-	}
+// validateCSVFile проверяет содержимое CSV файла, читая его блоками: в памяти
+// не больше одного блока независимо от размера файла
+func validateCSVFile(ctx context.Context, file io.Reader) error {
+	logger := loggerFrom(ctx)
+	logger.Info("Проверка файла")
 
-	// Проверяем что не пустой
-	if len(content) == 0 {
-		return fmt.Errorf("файл пустой")
-	}
+	limit := maxCSVBytes()
 
-	// Логируем первые 100 символов для отладки
-	if len(content) > 100 {
-		log.Printf("Первые 100 символов файла: %s", string(content[:100]))
-	} else {
-		log.Printf("Содержимое файла: %s", string(content))
+	// Конец предыдущего блока остается в начале буфера, чтобы найти паттерн на границе блоков
+	overlap := 0
+	for _, pattern := range dangerousPatterns {
+		overlap = max(overlap, len(pattern)-1)
+	}
+	buf := make([]byte, overlap+validateChunkSize)
+	lower := make([]byte, len(buf))
+
+	var size int64
+	tail := 0
+	for {
+		n, err := io.ReadFull(file, buf[tail:])
+		if n > 0 {
+			chunk := buf[tail : tail+n]
+			if size == 0 {
+				// Содержимое файла пишем только на уровне debug
+				if logger.Enabled(ctx, slog.LevelDebug) {
+					logger.Debug("Начало файла", "preview", string(chunk[:min(100, len(chunk))]))
+				}
+				if pattern, found := binaryHeader(chunk); found {
+					observeValidationFailure("binary")
+					logger.Warn("Обнаружен опасный паттерн", "pattern", pattern)
+					return fmt.Errorf("обнаружено потенциально опасное содержимое")
+				}
+			}
+
+			// Проверяем размер, не дочитывая слишком большой файл
+			size += int64(n)
+			if size > limit {
+				observeValidationFailure("size")
+				return fmt.Errorf("файл слишком большой (максимум %dMB)", cfg().MaxCSVSizeMB)
+			}
+
+			// Проверяем на вредоносный код
+			window := buf[:tail+n]
+			if pattern, found := containsMaliciousContent(asciiLower(lower, window)); found {
+				observeValidationFailure("dangerous_pattern")
+				logger.Warn("Обнаружен опасный паттерн", "pattern", pattern)
+				return fmt.Errorf("обнаружено потенциально опасное содержимое")
+			}
+
+			tail = min(overlap, len(window))
+			copy(buf, window[len(window)-tail:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			observeValidationFailure("read")
+			return fmt.Errorf("ошибка чтения файла: %v", err)
+		}
 	}
 
-	// Проверяем на вредоносный код
-	if containsMaliciousContent(string(content)) {
-		return fmt.Errorf("обнаружено потенциально опасное содержимое")
+	// Проверяем что не пустой
+	if size == 0 {
+		observeValidationFailure("empty")
+		return fmt.Errorf("файл пустой")
 	}
 
-	log.Printf("Файл прошел проверку безопасности")
+	logger.Info("Файл прошел проверку безопасности", "size", size)
 	return nil
 }
 
 // validateCSVFileFromPath проверяет CSV файл по пути
-func validateCSVFileFromPath(filePath string) error {
+func validateCSVFileFromPath(ctx context.Context, filePath string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("не удалось открыть файл: %v", err)
 	}
 	defer file.Close()
 
-	return validateCSVFile(file)
+	return validateCSVFile(ctx, file)
 }
 
-// containsMaliciousContent проверяет на опасный код для Linux
-func containsMaliciousContent(s string) bool {
-	lower := strings.ToLower(s)
-
-	// Опасные паттерны для Linux сервера
-	dangerousPatterns := []string{
-		// Shell injection
-		"$((", "`", "&&", "||", "|", ">", "<", ";",
-		// Command execution
-		"/bin/bash", "/bin/sh", "bash -c", "sh -c", "eval ", "exec(",
-		// System commands
-		"rm -rf", "rm -f", "chmod", "chown", "sudo", "su ",
-		"wget", "curl", "nc ", "netcat", "ssh ", "scp ",
-		// File system access
-		"/etc/passwd", "/etc/shadow", "/etc/hosts", "/proc/",
-		"../../", "../etc/", "/root/", "/home/",
-		// Network
-		"127.0.0.1", "localhost", "0.0.0.0",
-		// Code injection
-		"<script", "javascript:", "vbscript:", "onload=", "onerror=",
-		"<iframe", "<object", "<embed",
-		// SQL injection (базовые)
-		"union select", "drop table", "insert into", "delete from",
-		"update set", "create table", "alter table",
-		// PHP injection
-		"<?php", "<?=", "system(", "shell_exec(", "exec(",
-		"passthru(", "proc_open", "popen(",
+// asciiLower переводит латиницу в нижний регистр в буфер dst; все паттерны - ASCII,
+// а остальные байты остаются как есть, поэтому граница блока не портит UTF-8
+func asciiLower(dst, src []byte) []byte {
+	dst = dst[:len(src)]
+	for i, c := range src {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst[i] = c
 	}
+	return dst
+}
 
+// containsMaliciousContent проверяет блок в нижнем регистре на опасный код и возвращает найденный паттерн
+func containsMaliciousContent(lower []byte) (string, bool) {
 	for _, pattern := range dangerousPatterns {
-		if strings.Contains(lower, pattern) {
-			log.Printf("Обнаружен опасный паттерн: %s", pattern)
-			return true
+		if bytes.Contains(lower, []byte(pattern)) {
+			return pattern, true
 		}
 	}
+	return "", false
+}
 
-	// Проверка на бинарные файлы (первые байты)
-	if len(s) > 4 {
+// binaryHeader проверяет начало файла на признаки исполняемого файла
+func binaryHeader(head []byte) (string, bool) {
+	if len(head) > 4 {
 		// ELF binary
-		if s[0] == 0x7f && s[1] == 'E' && s[2] == 'L' && s[3] == 'F' {
-			return true
+		if head[0] == 0x7f && head[1] == 'E' && head[2] == 'L' && head[3] == 'F' {
+			return "ELF", true
 		}
 		// PE executable (Windows)
-		if s[0] == 'M' && s[1] == 'Z' {
-			return true
+		if head[0] == 'M' && head[1] == 'Z' {
+			return "MZ", true
 		}
 	}
-
-	return false
+	return "", false
 }
 
-// uploadFile отправляет файл в PIRELLI (для автоматической отправки)
-func uploadFile(filePath string) error {
-	// Сначала проверяем файл
-	if err := validateCSVFileFromPath(filePath); err != nil {
-		return fmt.Errorf("ошибка проверки файла: %v", err)
-	}
-
-	response, err := uploadFileToPirelli(filePath, filepath.Base(filePath))
-	if err != nil {
-		return err
-	}
-
-	log.Printf("Отправка успешна! Статус: %t, Сообщение: %s", response.Status, response.Message)
-	if response.Status && len(response.Data) > 0 {
-		lastUpload := response.Data[len(response.Data)-1]
-		log.Printf("Последняя загрузка: %s (%s)", lastUpload.OriginalName, lastUpload.DateTime)
-	}
+// uploadFileToPirelli отправляет файл на сервер PIRELLI от имени creds. Ошибка - *pirelli.Error,
+// если файл не принят; ответ PIRELLI возвращается и вместе с ошибкой, если он пришел в JSON
+func uploadFileToPirelli(ctx context.Context, creds Credentials, filePath, fileName string) (response *pirelli.Response, err error) {
+	started := time.Now()
+	bodySize := 0
+	uploadsInFlight.Add(1)
+	defer func() {
+		uploadsInFlight.Add(-1)
+		observeUpload(ctx, started, bodySize, response, err)
+		rememberUpload(ctx, fileName, response, err)
+		notifyUploadResult(ctx, creds.Login, filePath, fileName, response, err)
+	}()
 
-	return nil
-}
-
-// uploadFileToPirelli отправляет файл на сервер PIRELLI
-func uploadFileToPirelli(filePath, fileName string) (*PirelliResponse, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("не удалось открыть файл: %v", err)
 	}
 	defer file.Close()
 
-	// Создаем буфер для multipart формы
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
-
-	// Устанавливаем ТОЧНЫЙ boundary как в 1С
-	boundary := "----WebKitFormBoundary7MA4YWxkTrZu0gW"
-	writer.SetBoundary(boundary)
-
-	// Добавляем поля формы в ТОЧНОМ порядке как в примере
-	fields := []struct {
-		name  string
-		value string
-	}{
-		{"action", "upload"},
-		{"auth_login", config.AuthLogin},
-		{"auth_token", config.AuthToken},
-	}
-
-	for _, field := range fields {
-		err = writer.WriteField(field.name, field.value)
-		if err != nil {
-			return nil, fmt.Errorf("ошибка добавления %s: %v", field.name, err)
-		}
-	}
-
-	// Создаем заголовок для файла с правильным Content-Type
-	headers := make(textproto.MIMEHeader)
-	headers.Set("Content-Disposition",
-		fmt.Sprintf(`form-data; name="file"; filename="%s"`, fileName))
-	headers.Set("Content-Type", "text/csv")
-
-	// Создаем часть для файла
-	part, err := writer.CreatePart(headers)
-	if err != nil {
-		return nil, fmt.Errorf("не удалось создать часть для файла: %v", err)
-	}
-
-	// Копируем содержимое файла
-	_, err = io.Copy(part, file)
+	info, err := file.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("не удалось скопировать содержимое файла: %v", err)
+		return nil, fmt.Errorf("не удалось открыть файл: %v", err)
 	}
 
-	// Закрываем writer для завершения формы
-	err = writer.Close()
+	client, err := newPirelliClient(ctx, creds)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка при закрытии writer: %v", err)
+		return nil, err
 	}
-
-	// Логируем первые 500 байт тела для отладки
-	bodyPreview := requestBody.Bytes()
-	previewLen := min(500, len(bodyPreview))
-	log.Printf("Размер тела запроса: %d байт", len(bodyPreview))
-	log.Printf("Первые %d байт тела: %s", previewLen, string(bodyPreview[:previewLen]))
-
-	// Создаем HTTP запрос
-	req, err := http.NewRequest("POST", config.BaseURL, &requestBody)
+	size, err := client.UploadSize(fileName, info.Size())
 	if err != nil {
-		return nil, fmt.Errorf("ошибка создания запроса: %v", err)
+		return nil, err
 	}
+	bodySize = int(size)
+	return client.Upload(ctx, fileName, file, info.Size())
+}
 
-	// Устанавливаем Content-Type с boundary
-	contentType := writer.FormDataContentType()
-	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-
-	// Выполняем запрос
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// splitList разбивает список через запятую, отбрасывая пустые элементы
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	log.Printf("Выполняем запрос к %s", config.BaseURL)
-	log.Printf("Content-Type: %s", contentType)
-	log.Printf("Имя файла: %s", fileName)
-
-	resp, err := client.Do(req)
+// countCSVRows считает строки данных в CSV файле (без заголовка)
+func countCSVRows(filePath string) int {
+	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка при выполнении запроса: %v", err)
+		return 0
 	}
-	defer resp.Body.Close()
+	defer file.Close()
 
-	// Читаем ответ
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения ответа: %v", err)
+	rows := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			rows++
+		}
 	}
 
-	log.Printf("Ответ от PIRELLI: статус %d, тело: %s", resp.StatusCode, string(body))
-
-	// Парсим JSON ответ
-	var response PirelliResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка парсинга JSON ответа: %v", err)
+	if rows > 0 {
+		rows-- // заголовок
 	}
-
-	return &response, nil
-}
-
-// generatePirelliFilename генерирует имя файла по формату PIRELLI
-func generatePirelliFilename() string {
-	now := time.Now()
-	return fmt.Sprintf("ir_%s_%s.csv", config.AuthLogin, now.Format("20060102_150405"))
-}
-
-// embeddedFormTemplate возвращает встроенный HTML шаблон на случай отсутствия файла
-func embeddedFormTemplate() string {
-	// Простой fallback шаблон если файл form.html не найден
-	return `<!DOCTYPE html>
-<html>
-<head><title>Загрузка отчетов</title></head>
-<body>
-	<h1>Загрузка отчетов {{.CompanyName}}</h1>
-	<p>Файл шаблона не найден. Пожалуйста, создайте templates/form.html</p>
-</body>
-</html>`
+	return rows
 }